@@ -1,17 +1,41 @@
 /**
- * En/Decrypt using the AES block cipher in ECB or CBC mode.
+ * En/Decrypt using the AES block cipher in ECB, CBC or CTR mode.
  *
  * In ECB mode, this is Equivalent to:
    KEY=59454c4c4f57205355424d4152494e45
-   openssl enc -aes-128-ecb -nosalt -a -nopad -in t.txt -K $KEY -out t.txt.enc
+   openssl enc -aes-128-ecb -nosalt -a -in t.txt -K $KEY -out t.txt.enc
    openssl enc -aes-128-ecb -nosalt -a -d -in t.txt.enc -K $KEY
- * Decrypting, OpenSSL ignores \n in base64'd input. The hex key is
+ * Both use PKCS#7 padding. Decrypting, OpenSSL ignores \n in base64'd input.
+ * The hex key is
  * ''.join('%x' % ord(c) for c in 'YELLOW SUBMARINE').
+ *
+ * The key is given as a literal string argument, or with --key-hex,
+ * --key-base64 or --key-file (raw bytes). Its length selects AES-128, -192 or
+ * -256; --key-size checks that it is the expected size.
+ *
+ * CBC and CTR use an all-zero IV (or nonce) unless one is given with --iv. With
+ * --random-iv, encryption picks a random IV and outputs IV || ciphertext, and
+ * decryption reads the IV from the start of its input.
+ *
+ * With --password, the key (and IV) are derived from the password and a random
+ * salt, and the output is in OpenSSL's Salted__ format, as from:
+   openssl enc -aes-128-cbc -md sha256 -pass pass:PASSWORD -a -in t.txt
+ *
+ * Input is read from stdin and output written to stdout a block at a time, so
+ * files of any size are en/decrypted in constant memory.
  */
 
 package main
 
 import (
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "io"
+    "io/ioutil"
     "log"
     "os"
 
@@ -22,6 +46,105 @@ import (
 )
 
 
+/** Reads the key from whichever one of the key options or argument is given. */
+func read_key(
+    key_hex string,
+    key_base64 string,
+    key_file string,
+    args []string) (*blocks.Blocks, error) {
+  var key *blocks.Blocks
+  var err error
+  sources := 0
+  if key_hex != "" {
+    key, err = blocks.ParseHex(key_hex)
+    sources++
+  }
+  if key_base64 != "" {
+    key, err = blocks.ParseBase64(key_base64)
+    sources++
+  }
+  if key_file != "" {
+    var data []byte
+    data, err = ioutil.ReadFile(key_file)
+    key = blocks.FromBytes(data)
+    sources++
+  }
+  if len(args) == 1 {
+    key = blocks.FromString(args[0])
+    sources++
+  } else if len(args) > 1 {
+    return nil, fmt.Errorf(
+        "Expected at most one key argument, got %d.", len(args))
+  }
+  if sources != 1 {
+    return nil, fmt.Errorf(
+        "Give exactly one of a key argument, --key-hex, --key-base64 or " +
+        "--key-file.")
+  }
+  if err != nil {
+    return nil, fmt.Errorf("Cannot read key: %w", err)
+  }
+  return key, nil
+}
+
+
+/** Adapts the hex encoder, which needs no flushing, to io.WriteCloser. */
+type nop_closer struct {
+  io.Writer
+}
+
+
+func (nop_closer) Close() error {
+  return nil
+}
+
+
+/**
+ * Parses an IV of iv_size bytes, given as hex or, if it is not valid hex, as
+ * base64.
+ */
+func parse_iv(encoded string, iv_size int) (*blocks.Blocks, error) {
+  encoding := "hex"
+  iv, err := blocks.ParseHex(encoded)
+  if err != nil {
+    encoding = "base64"
+    iv, err = blocks.ParseBase64(encoded)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("IV %q is neither hex nor base64: %w", encoded, err)
+  }
+  if iv.Len() != iv_size {
+    return nil, fmt.Errorf(
+        "IV is %d bytes (as %s), but this mode needs %d.",
+        iv.Len(), encoding, iv_size)
+  }
+  return iv, nil
+}
+
+
+/** Checks the key length is valid for AES, and matches key_size if given. */
+func check_key_size(key *blocks.Blocks, key_size int) error {
+  key_bits := key.Len() * 8
+  switch key_size {
+  case 0:
+    if key_bits != 128 && key_bits != 192 && key_bits != 256 {
+      return fmt.Errorf(
+          "Key is %d bits (%d bytes), but AES needs 128, 192 or 256.",
+          key_bits, key.Len())
+    }
+  case 128, 192, 256:
+    if key_bits != key_size {
+      return fmt.Errorf(
+          "Key is %d bits (%d bytes), but --key-size is %d.",
+          key_bits, key.Len(), key_size)
+    }
+  default:
+    return fmt.Errorf("--key-size must be 128, 192 or 256, not %d.", key_size)
+  }
+  return nil
+}
+
+
 func main() {
   var decrypt = goopt.Flag(
       []string{"-d", "--decrypt"},
@@ -30,52 +153,185 @@ func main() {
       "Encrypt.")
   var mode = goopt.Alternatives(
       []string{"-m", "--mode"},
-      []string{"ecb", "cbc"},
+      []string{"ecb", "cbc", "ctr"},
       "Which mode of operation to use with the block cipher.")
+  var counter = goopt.Alternatives(
+      []string{"--counter"},
+      []string{"le64", "be128"},
+      "CTR counter layout: 64-bit LE nonce and count, or 128-bit BE counter.")
   var format = goopt.Alternatives(
       []string{"-f", "--format"},
       []string{"hex", "base64"},
       "How to format the output ciphertext.")
+  var key_hex = goopt.String(
+      []string{"--key-hex"}, "", "The key, as hex.")
+  var key_base64 = goopt.String(
+      []string{"--key-base64"}, "", "The key, as base64.")
+  var key_file = goopt.String(
+      []string{"--key-file"}, "", "A file containing the raw key bytes.")
+  var key_size = goopt.Int(
+      []string{"--key-size"},
+      0,
+      "Expected key size in bits: 128, 192 or 256. By default, any of these.")
+  var iv_text = goopt.String(
+      []string{"--iv"}, "", "The IV (or CTR nonce), as hex or base64.")
+  var random_iv = goopt.Flag(
+      []string{"--random-iv"},
+      []string{},
+      "Use a random IV, and frame the ciphertext as IV || ciphertext.",
+      "")
+  var password = goopt.String(
+      []string{"--password"},
+      "",
+      "Derive the key and IV from a password, in OpenSSL's Salted__ format.")
+  var md = goopt.Alternatives(
+      []string{"--md"},
+      []string{"sha256", "md5"},
+      "Digest used to derive the key from --password.")
   goopt.Description = func() string {
     return "En/Decrypt using AES in different modes of operation."
   }
   goopt.Parse(nil)
 
-  if len(goopt.Args) != 1 {
-    log.Fatalf(goopt.Synopsis())
+  var key *blocks.Blocks
+  var err error
+  if *password == "" {
+    key, err = read_key(*key_hex, *key_base64, *key_file, goopt.Args)
+    if err != nil {
+      log.Fatalf("%s\n%s", err, goopt.Synopsis())
+    }
+    if err := check_key_size(key, *key_size); err != nil {
+      log.Fatal(err)
+    }
+  } else {
+    if *key_hex != "" || *key_base64 != "" || *key_file != "" ||
+        len(goopt.Args) > 0 {
+      log.Fatalf("Give either --password or a key, not both.")
+    }
+    if *key_size == 0 {
+      *key_size = 128
+    }
+    if *key_size != 128 && *key_size != 192 && *key_size != 256 {
+      log.Fatalf("--key-size must be 128, 192 or 256, not %d.", *key_size)
+    }
+  }
+  var new_hash func() hash.Hash = sha256.New
+  if *md == "md5" {
+    new_hash = md5.New
+  }
+  counter_format := aes_modes.CounterLittleEndian64
+  if *counter == "be128" {
+    counter_format = aes_modes.CounterBigEndian128
+  }
+  iv_size := 0
+  switch *mode {
+  case "cbc":
+    iv_size = 16
+  case "ctr":
+    iv_size = 8
+    if counter_format == aes_modes.CounterBigEndian128 {
+      iv_size = 16
+    }
+  }
+  if iv_size == 0 && (*iv_text != "" || *random_iv) {
+    log.Fatalf("Mode %s does not use an IV.", *mode)
+  }
+  if *iv_text != "" && *random_iv {
+    log.Fatalf("Give at most one of --iv and --random-iv.")
+  }
+  if *password != "" && (*iv_text != "" || *random_iv) {
+    log.Fatalf("With --password, the IV is derived from the password.")
+  }
+  if *password != "" && *mode == "ctr" &&
+      counter_format != aes_modes.CounterBigEndian128 {
+    log.Fatalf("OpenSSL's CTR mode needs --counter be128.")
+  }
+  iv := blocks.FromBytes(make([]byte, iv_size))
+  if *iv_text != "" {
+    iv, err = parse_iv(*iv_text, iv_size)
+    if err != nil {
+      log.Fatal(err)
+    }
   }
-  key := blocks.FromString(goopt.Args[0])
-  iv := blocks.FromBytes(make([]byte, 16, 16))
+
+  stream_mode := aes_modes.ModeEcb
+  switch *mode {
+  case "cbc":
+    stream_mode = aes_modes.ModeCbc
+  case "ctr":
+    stream_mode = aes_modes.ModeCtrLittleEndian64
+    if counter_format == aes_modes.CounterBigEndian128 {
+      stream_mode = aes_modes.ModeCtrBigEndian128
+    }
+  }
+
   if *decrypt {
-    ciphertext := blocks.FromBase64Stream(os.Stdin)
-    var plaintext *blocks.Blocks
-    switch *mode {
-    case "ecb":
-      plaintext = aes_modes.EcbDecrypt(ciphertext, key)
-    case "cbc":
-      plaintext = aes_modes.CbcDecrypt(ciphertext, key, iv)
-    default:
-      panic(mode)
+    // The base64 decoder skips the newlines in OpenSSL's output.
+    input := base64.NewDecoder(base64.StdEncoding, os.Stdin)
+    if *random_iv {
+      iv_bytes := make([]byte, iv_size)
+      if _, err := io.ReadFull(input, iv_bytes); err != nil {
+        log.Fatalf("Cannot read the %d-byte IV from input: %s", iv_size, err)
+      }
+      iv = blocks.FromBytes(iv_bytes)
     }
-    log.Printf("Decrypted:\n%s\n", plaintext.ToString())
-  } else {
-    plaintext := blocks.FromStringStream(os.Stdin)
-    var ciphertext *blocks.Blocks
-    switch *mode {
-    case "ecb":
-      ciphertext = aes_modes.EcbEncrypt(plaintext, key)
-    case "cbc":
-      ciphertext = aes_modes.CbcEncrypt(plaintext, key, iv)
-    default:
-      panic(*mode)
+    if *password != "" {
+      header := make([]byte, aes_modes.OpensslHeaderSize)
+      if _, err := io.ReadFull(input, header); err != nil {
+        log.Fatalf("Cannot read the Salted__ header: %s", err)
+      }
+      salt, _, err := aes_modes.OpensslUnframe(blocks.FromBytes(header))
+      if err != nil {
+        log.Fatal(err)
+      }
+      key, iv = aes_modes.EvpBytesToKey(
+          blocks.FromString(*password), salt, new_hash, *key_size / 8, iv_size)
+    }
+    plaintext, err := aes_modes.NewDecryptReader(input, stream_mode, key, iv)
+    if err != nil {
+      log.Fatal(err)
     }
+    if _, err := io.Copy(os.Stdout, plaintext); err != nil {
+      log.Fatal(err)
+    }
+  } else {
+    var output io.WriteCloser
     switch *format {
     case "hex":
-      log.Printf("Encrypted:\n%s\n", ciphertext.ToHex())
+      output = nop_closer{hex.NewEncoder(os.Stdout)}
     case "base64":
-      log.Printf("Encrypted:\n%s\n", ciphertext.ToBase64())
+      output = base64.NewEncoder(base64.StdEncoding, os.Stdout)
     default:
       panic(*format)
     }
+    if *random_iv {
+      iv = blocks.RandomBlock(iv_size)
+      if _, err := output.Write(iv.ToBytes()); err != nil {
+        log.Fatal(err)
+      }
+    }
+    if *password != "" {
+      salt := blocks.RandomBlock(aes_modes.OpensslSaltSize)
+      key, iv = aes_modes.EvpBytesToKey(
+          blocks.FromString(*password), salt, new_hash, *key_size / 8, iv_size)
+      header := aes_modes.OpensslFrame(salt, blocks.New())
+      if _, err := output.Write(header.ToBytes()); err != nil {
+        log.Fatal(err)
+      }
+    }
+    ciphertext, err := aes_modes.NewEncryptWriter(output, stream_mode, key, iv)
+    if err != nil {
+      log.Fatal(err)
+    }
+    if _, err := io.Copy(ciphertext, os.Stdin); err != nil {
+      log.Fatal(err)
+    }
+    if err := ciphertext.Close(); err != nil {
+      log.Fatal(err)
+    }
+    if err := output.Close(); err != nil {
+      log.Fatal(err)
+    }
+    fmt.Println()
   }
 }
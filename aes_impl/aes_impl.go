@@ -0,0 +1,352 @@
+/**
+ * AES implemented from scratch, for studying attacks on (reduced-round) AES.
+ * The round count is configurable and a hook can observe or modify the state
+ * after each step. Cipher satisfies crypto/cipher.Block, so it can be used with
+ * aes_modes.WithCipher.
+ * https://csrc.nist.gov/publications/detail/fips/197/final
+ */
+
+package aes_impl
+
+import "errors"
+import "fmt"
+
+
+const BlockSize int = 16
+
+
+var ErrInvalidKeySize = errors.New("Invalid AES key size.")
+var ErrInvalidRounds = errors.New("Invalid AES round count.")
+
+
+/**
+ * The AES state: 16 bytes in FIPS-197's column-major order, so byte r + 4*c is
+ * row r of column c. This is also the order of the input and output bytes.
+ */
+type State [BlockSize]byte
+
+
+/** A step of the (inverse) cipher, as passed to a RoundHook. */
+type Step int
+
+const (
+  SubBytes Step = iota
+  ShiftRows
+  MixColumns
+  AddRoundKey
+  InvSubBytes
+  InvShiftRows
+  InvMixColumns
+)
+
+
+func (s Step) String() string {
+  switch s {
+  case SubBytes:
+    return "SubBytes"
+  case ShiftRows:
+    return "ShiftRows"
+  case MixColumns:
+    return "MixColumns"
+  case AddRoundKey:
+    return "AddRoundKey"
+  case InvSubBytes:
+    return "InvSubBytes"
+  case InvShiftRows:
+    return "InvShiftRows"
+  case InvMixColumns:
+    return "InvMixColumns"
+  default:
+    return fmt.Sprintf("Step(%d)", int(s))
+  }
+}
+
+
+/**
+ * Called after each step, with the index of the round (and round key) the
+ * step belongs to. Round 0 is the initial AddRoundKey when encrypting, and the
+ * final one when decrypting. The hook may modify the state.
+ */
+type RoundHook func(round int, step Step, state *State)
+
+
+var sbox [256]byte
+var inv_sbox [256]byte
+
+
+/** Multiplies by x (0x02) in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1. */
+func xtime(b byte) byte {
+  if b & 0x80 != 0 {
+    return b << 1 ^ 0x1b
+  }
+  return b << 1
+}
+
+
+/** Multiplies in GF(2^8). */
+func gf_mul(a byte, b byte) byte {
+  var product byte
+  for b > 0 {
+    if b & 1 != 0 {
+      product ^= a
+    }
+    a = xtime(a)
+    b >>= 1
+  }
+  return product
+}
+
+
+/** Builds the S-box: the multiplicative inverse followed by an affine map. */
+func init() {
+  for i := 0; i < 256; i++ {
+    var inverse byte
+    for j := 1; i != 0 && j < 256; j++ {
+      if gf_mul(byte(i), byte(j)) == 1 {
+        inverse = byte(j)
+        break
+      }
+    }
+    s := inverse
+    for shift := uint(1); shift <= 4; shift++ {
+      s ^= inverse << shift | inverse >> (8 - shift)
+    }
+    s ^= 0x63
+    sbox[i] = s
+    inv_sbox[s] = byte(i)
+  }
+}
+
+
+func SubByte(b byte) byte {
+  return sbox[b]
+}
+
+
+func InvSubByte(b byte) byte {
+  return inv_sbox[b]
+}
+
+
+func (s *State) SubBytes() {
+  for i, b := range s {
+    s[i] = sbox[b]
+  }
+}
+
+
+func (s *State) InvSubBytes() {
+  for i, b := range s {
+    s[i] = inv_sbox[b]
+  }
+}
+
+
+/** Rotates row r left by r columns. */
+func (s *State) ShiftRows() {
+  orig := *s
+  for r := 1; r < 4; r++ {
+    for c := 0; c < 4; c++ {
+      s[r + 4 * c] = orig[r + 4 * ((c + r) % 4)]
+    }
+  }
+}
+
+
+func (s *State) InvShiftRows() {
+  orig := *s
+  for r := 1; r < 4; r++ {
+    for c := 0; c < 4; c++ {
+      s[r + 4 * ((c + r) % 4)] = orig[r + 4 * c]
+    }
+  }
+}
+
+
+/** Multiplies each column by the matrix with rows (a b c d), rotated. */
+func (s *State) mix_columns(a, b, c, d byte) {
+  for col := 0; col < 4; col++ {
+    var column [4]byte
+    copy(column[:], s[4 * col:4 * col + 4])
+    for r := 0; r < 4; r++ {
+      s[r + 4 * col] = gf_mul(column[r], a) ^
+          gf_mul(column[(r + 1) % 4], b) ^
+          gf_mul(column[(r + 2) % 4], c) ^
+          gf_mul(column[(r + 3) % 4], d)
+    }
+  }
+}
+
+
+func (s *State) MixColumns() {
+  s.mix_columns(0x02, 0x03, 0x01, 0x01)
+}
+
+
+func (s *State) InvMixColumns() {
+  s.mix_columns(0x0e, 0x0b, 0x0d, 0x09)
+}
+
+
+func (s *State) AddRoundKey(round_key *State) {
+  for i := range s {
+    s[i] ^= round_key[i]
+  }
+}
+
+
+/** An AES key schedule with a configurable number of rounds. */
+type Cipher struct {
+  round_keys []State  // one more than the number of rounds
+  hook RoundHook
+}
+
+
+/** Returns standard AES-128, -192 or -256, depending on the key length. */
+func NewCipher(key []byte) (*Cipher, error) {
+  switch len(key) {
+  case 16:
+    return NewCipherRounds(key, 10)
+  case 24:
+    return NewCipherRounds(key, 12)
+  case 32:
+    return NewCipherRounds(key, 14)
+  default:
+    return nil, fmt.Errorf(
+        "%w Got %d bytes, need 16, 24 or 32.", ErrInvalidKeySize, len(key))
+  }
+}
+
+
+/**
+ * Returns AES with the given number of rounds (at least 1). As in the standard
+ * cipher, the last round omits MixColumns. More rounds than the standard are
+ * allowed; the key schedule is extended as usual.
+ */
+func NewCipherRounds(key []byte, rounds int) (*Cipher, error) {
+  if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+    return nil, fmt.Errorf(
+        "%w Got %d bytes, need 16, 24 or 32.", ErrInvalidKeySize, len(key))
+  }
+  if rounds < 1 {
+    return nil, fmt.Errorf(
+        "%w Got %d, need at least 1.", ErrInvalidRounds, rounds)
+  }
+  return &Cipher{round_keys: expand_key(key, rounds)}, nil
+}
+
+
+/** FIPS-197 KeyExpansion, producing rounds + 1 round keys. */
+func expand_key(key []byte, rounds int) []State {
+  key_words := len(key) / 4
+  words := make([][4]byte, 4 * (rounds + 1))
+  var rcon byte = 0x01
+  for i := range words {
+    if i < key_words {
+      copy(words[i][:], key[4 * i:4 * i + 4])
+      continue
+    }
+    temp := words[i - 1]
+    if i % key_words == 0 {
+      temp = [4]byte{
+          sbox[temp[1]] ^ rcon, sbox[temp[2]], sbox[temp[3]], sbox[temp[0]]}
+      rcon = xtime(rcon)
+    } else if key_words > 6 && i % key_words == 4 {
+      for j := range temp {
+        temp[j] = sbox[temp[j]]
+      }
+    }
+    for j := range temp {
+      words[i][j] = words[i - key_words][j] ^ temp[j]
+    }
+  }
+  round_keys := make([]State, rounds + 1)
+  for i, word := range words {
+    copy(round_keys[i / 4][4 * (i % 4):], word[:])
+  }
+  return round_keys
+}
+
+
+/** Sets (or, with nil, clears) the hook called after each step. */
+func (c *Cipher) SetRoundHook(hook RoundHook) {
+  c.hook = hook
+}
+
+
+func (c *Cipher) Rounds() int {
+  return len(c.round_keys) - 1
+}
+
+
+/** Returns a copy of the round key for round i (0 to Rounds()). */
+func (c *Cipher) RoundKey(i int) State {
+  return c.round_keys[i]
+}
+
+
+func (c *Cipher) BlockSize() int {
+  return BlockSize
+}
+
+
+func (c *Cipher) after(round int, step Step, state *State) {
+  if c.hook != nil {
+    c.hook(round, step, state)
+  }
+}
+
+
+func check_lengths(dst, src []byte) {
+  if len(src) < BlockSize || len(dst) < BlockSize {
+    panic(fmt.Sprintf(
+        "AES needs %d-byte blocks, got src %d, dst %d.",
+        BlockSize, len(src), len(dst)))
+  }
+}
+
+
+func (c *Cipher) Encrypt(dst, src []byte) {
+  check_lengths(dst, src)
+  var state State
+  copy(state[:], src)
+  rounds := c.Rounds()
+  state.AddRoundKey(&c.round_keys[0])
+  c.after(0, AddRoundKey, &state)
+  for round := 1; round <= rounds; round++ {
+    state.SubBytes()
+    c.after(round, SubBytes, &state)
+    state.ShiftRows()
+    c.after(round, ShiftRows, &state)
+    if round < rounds {
+      state.MixColumns()
+      c.after(round, MixColumns, &state)
+    }
+    state.AddRoundKey(&c.round_keys[round])
+    c.after(round, AddRoundKey, &state)
+  }
+  copy(dst, state[:])
+}
+
+
+func (c *Cipher) Decrypt(dst, src []byte) {
+  check_lengths(dst, src)
+  var state State
+  copy(state[:], src)
+  rounds := c.Rounds()
+  for round := rounds; round >= 1; round-- {
+    state.AddRoundKey(&c.round_keys[round])
+    c.after(round, AddRoundKey, &state)
+    if round < rounds {
+      state.InvMixColumns()
+      c.after(round, InvMixColumns, &state)
+    }
+    state.InvShiftRows()
+    c.after(round, InvShiftRows, &state)
+    state.InvSubBytes()
+    c.after(round, InvSubBytes, &state)
+  }
+  state.AddRoundKey(&c.round_keys[0])
+  c.after(0, AddRoundKey, &state)
+  copy(dst, state[:])
+}
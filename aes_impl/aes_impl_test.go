@@ -0,0 +1,135 @@
+package aes_impl
+
+import "bytes"
+import "crypto/aes"
+import "encoding/hex"
+import "testing"
+
+import "../aes_modes"
+import "../blocks"
+
+
+func TestFips197Vectors(t *testing.T) {
+  // FIPS-197 Appendix C.
+  plaintext, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+  for _, vector := range []struct{ key, ciphertext string }{
+      {"000102030405060708090a0b0c0d0e0f",
+       "69c4e0d86a7b0430d8cdb78070b4c55a"},
+      {"000102030405060708090a0b0c0d0e0f1011121314151617",
+       "dda97ca4864cdfe06eaf70a0ec0d7191"},
+      {"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+       "8ea2b7ca516745bfeafc49904b496089"}} {
+    key, _ := hex.DecodeString(vector.key)
+    c, err := NewCipher(key)
+    if err != nil {
+      t.Fatal(err)
+    }
+    ciphertext := make([]byte, BlockSize)
+    c.Encrypt(ciphertext, plaintext)
+    if hex.EncodeToString(ciphertext) != vector.ciphertext {
+      t.Errorf(
+          "Key %s: expected %s but got %x.",
+          vector.key, vector.ciphertext, ciphertext)
+    }
+    decrypted := make([]byte, BlockSize)
+    c.Decrypt(decrypted, ciphertext)
+    if !bytes.Equal(decrypted, plaintext) {
+      t.Errorf("Key %s: decrypted as %x.", vector.key, decrypted)
+    }
+  }
+}
+
+
+func TestMatchesStandardLibrary(t *testing.T) {
+  for _, key_size := range [...]int{16, 24, 32} {
+    for trial := 0; trial < 20; trial++ {
+      key := blocks.RandomBlock(key_size).ToBytes()
+      plaintext := blocks.RandomBlock(BlockSize).ToBytes()
+      ours, _ := NewCipher(key)
+      theirs, _ := aes.NewCipher(key)
+      expected := make([]byte, BlockSize)
+      theirs.Encrypt(expected, plaintext)
+      actual := make([]byte, BlockSize)
+      ours.Encrypt(actual, plaintext)
+      if !bytes.Equal(expected, actual) {
+        t.Errorf(
+            "Key %x, plaintext %x: expected %x but got %x.",
+            key, plaintext, expected, actual)
+      }
+    }
+  }
+}
+
+
+func TestWithModes(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.FromString("PUMPKIN PIE BOWL")
+  plaintext := blocks.FromString("Ice, Ice, baby. Too cold, too cold.")
+  c, _ := NewCipher(key.ToBytes())
+  expected := aes_modes.CbcEncrypt(plaintext, key, iv)
+  actual := aes_modes.WithCipher(c).CbcEncrypt(plaintext, iv)
+  if !blocks.Equal(expected, actual) {
+    t.Errorf("Expected %s but got %s.", expected.ToHex(), actual.ToHex())
+  }
+}
+
+
+func TestRoundHook(t *testing.T) {
+  c, _ := NewCipherRounds([]byte("YELLOW SUBMARINE"), 4)
+  steps := 0
+  c.SetRoundHook(func(round int, step Step, state *State) {
+    steps++
+  })
+  plaintext := make([]byte, BlockSize)
+  ciphertext := make([]byte, BlockSize)
+  c.Encrypt(ciphertext, plaintext)
+  // Initial AddRoundKey, 3 full rounds of 4 steps, final round of 3.
+  if steps != 1 + 3 * 4 + 3 {
+    t.Errorf("Expected 16 hook calls but got %d.", steps)
+  }
+
+  // A fault injected before the last round's SubBytes changes one byte.
+  c.SetRoundHook(func(round int, step Step, state *State) {
+    if round == 3 && step == AddRoundKey {
+      state[0] ^= 0x01
+    }
+  })
+  faulted := make([]byte, BlockSize)
+  c.Encrypt(faulted, plaintext)
+  differing := 0
+  for i := range faulted {
+    if faulted[i] != ciphertext[i] {
+      differing++
+    }
+  }
+  if differing != 1 {
+    t.Errorf("Expected one faulted byte but %d differ.", differing)
+  }
+
+  c.SetRoundHook(nil)
+  decrypted := make([]byte, BlockSize)
+  c.Decrypt(decrypted, ciphertext)
+  if !bytes.Equal(decrypted, plaintext) {
+    t.Errorf("Reduced-round decryption gave %x.", decrypted)
+  }
+}
+
+
+func TestThreeRoundIntegral(t *testing.T) {
+  // With 3 rounds, encrypting all 256 values of one byte (the rest constant)
+  // gives ciphertexts which XOR to zero in every byte: the square property.
+  c, _ := NewCipherRounds([]byte("YELLOW SUBMARINE"), 3)
+  var sum State
+  for i := 0; i < 256; i++ {
+    plaintext := make([]byte, BlockSize)
+    plaintext[0] = byte(i)
+    ciphertext := make([]byte, BlockSize)
+    c.Encrypt(ciphertext, plaintext)
+    for j := range sum {
+      sum[j] ^= ciphertext[j]
+    }
+  }
+  if sum != (State{}) {
+    t.Errorf("Expected a zero sum over the lambda set but got %x.", sum)
+  }
+}
@@ -0,0 +1,315 @@
+/**
+ * Adapters from these modes to crypto/cipher's interfaces, so they can be used
+ * where Go code expects a cipher.BlockMode, cipher.Stream or cipher.AEAD. As
+ * with crypto/cipher's constructors, these panic on a bad IV or nonce size.
+ */
+
+package aes_modes
+
+import "crypto/cipher"
+import "fmt"
+
+import "../blocks"
+
+
+/** A cipher.BlockMode over a block_stream (ECB or CBC). */
+type block_mode struct {
+  stream *block_stream
+}
+
+
+func (m *Modes) new_block_mode(
+    mode Mode, iv []byte, decrypt bool) cipher.BlockMode {
+  var iv_blocks *blocks.Blocks
+  if iv != nil {
+    iv_blocks = blocks.FromBytes(append([]byte(nil), iv...))
+  }
+  stream, err := m.new_block_stream(mode, iv_blocks, decrypt)
+  if err != nil {
+    panic(err)
+  }
+  return &block_mode{stream: stream}
+}
+
+
+/** Returns a cipher.BlockMode which ECB-encrypts. */
+func (m *Modes) NewEcbEncrypter() cipher.BlockMode {
+  return m.new_block_mode(ModeEcb, nil, false)
+}
+
+
+func (m *Modes) NewEcbDecrypter() cipher.BlockMode {
+  return m.new_block_mode(ModeEcb, nil, true)
+}
+
+
+/**
+ * Returns a cipher.BlockMode which CBC-encrypts, chaining from one CryptBlocks
+ * call to the next, like cipher.NewCBCEncrypter.
+ */
+func (m *Modes) NewCbcEncrypter(iv []byte) cipher.BlockMode {
+  return m.new_block_mode(ModeCbc, iv, false)
+}
+
+
+func (m *Modes) NewCbcDecrypter(iv []byte) cipher.BlockMode {
+  return m.new_block_mode(ModeCbc, iv, true)
+}
+
+
+func (b *block_mode) BlockSize() int {
+  return b.stream.modes.BlockSize()
+}
+
+
+/** En/decrypts src (whole blocks) into dst, which may be the same slice. */
+func (b *block_mode) CryptBlocks(dst, src []byte) {
+  if len(src) % b.BlockSize() != 0 {
+    panic(fmt.Errorf(
+        "%w %d bytes is not a multiple of %d.",
+        ErrPartialBlock, len(src), b.BlockSize()))
+  }
+  if len(dst) < len(src) {
+    panic("Output smaller than input.")
+  }
+  copy(dst, src)
+  b.stream.crypt(dst[:len(src)])
+}
+
+
+/**
+ * A cipher.Stream which XORs with a keystream generated a block at a time,
+ * for CTR and OFB.
+ */
+type keystream_stream struct {
+  next_block func(keystream []byte)
+  keystream []byte
+  pos int  // bytes of keystream already used
+}
+
+
+func (s *keystream_stream) XORKeyStream(dst, src []byte) {
+  if len(dst) < len(src) {
+    panic("Output smaller than input.")
+  }
+  for i := range src {
+    if s.pos == len(s.keystream) {
+      s.next_block(s.keystream)
+      s.pos = 0
+    }
+    dst[i] = src[i] ^ s.keystream[s.pos]
+    s.pos++
+  }
+}
+
+
+/** Returns a cipher.Stream for CTR mode, like cipher.NewCTR for be128. */
+func (m *Modes) NewCtr(nonce []byte, format CounterFormat) cipher.Stream {
+  counter, err := format.initial_counter(
+      blocks.FromBytes(append([]byte(nil), nonce...)), m.BlockSize())
+  if err != nil {
+    panic(err)
+  }
+  keystream := make([]byte, m.BlockSize())
+  return &keystream_stream{
+      next_block: func(keystream []byte) {
+        m.block_cipher.Encrypt(keystream, counter)
+        format.increment(counter)
+      },
+      keystream: keystream,
+      pos: len(keystream)}
+}
+
+
+/** Returns a cipher.Stream for OFB mode, like cipher.NewOFB. */
+func (m *Modes) NewOfb(iv []byte) cipher.Stream {
+  if err := m.validate_iv(blocks.FromBytes(iv)); err != nil {
+    panic(err)
+  }
+  // Each keystream block is the encryption of the previous one.
+  keystream := append([]byte(nil), iv...)
+  return &keystream_stream{
+      next_block: func(keystream []byte) {
+        m.block_cipher.Encrypt(keystream, keystream)
+      },
+      keystream: keystream,
+      pos: len(keystream)}
+}
+
+
+/** A cipher.Stream for full-block CFB, which feeds back the ciphertext. */
+type cfb_stream struct {
+  modes *Modes
+  decrypt bool
+  keystream []byte
+  feedback []byte  // ciphertext of the current block, as far as pos
+  pos int
+}
+
+
+func (m *Modes) new_cfb(iv []byte, decrypt bool) cipher.Stream {
+  if err := m.validate_iv(blocks.FromBytes(iv)); err != nil {
+    panic(err)
+  }
+  return &cfb_stream{
+      modes: m,
+      decrypt: decrypt,
+      keystream: make([]byte, m.BlockSize()),
+      feedback: append([]byte(nil), iv...),
+      pos: m.BlockSize()}
+}
+
+
+/** Returns a cipher.Stream for CFB encryption, like cipher.NewCFBEncrypter. */
+func (m *Modes) NewCfbEncrypter(iv []byte) cipher.Stream {
+  return m.new_cfb(iv, false)
+}
+
+
+func (m *Modes) NewCfbDecrypter(iv []byte) cipher.Stream {
+  return m.new_cfb(iv, true)
+}
+
+
+func (s *cfb_stream) XORKeyStream(dst, src []byte) {
+  if len(dst) < len(src) {
+    panic("Output smaller than input.")
+  }
+  for i := range src {
+    if s.pos == len(s.keystream) {
+      s.modes.block_cipher.Encrypt(s.keystream, s.feedback)
+      s.pos = 0
+    }
+    in := src[i]
+    out := in ^ s.keystream[s.pos]
+    if s.decrypt {
+      s.feedback[s.pos] = in
+    } else {
+      s.feedback[s.pos] = out
+    }
+    dst[i] = out
+    s.pos++
+  }
+}
+
+
+/** A cipher.AEAD over one of the Seal/Open pairs. */
+type aead struct {
+  nonce_size int
+  overhead int
+  seal func(
+      plaintext, nonce, additional_data *blocks.Blocks) (*blocks.Blocks, error)
+  open func(
+      sealed, nonce, additional_data *blocks.Blocks) (*blocks.Blocks, error)
+}
+
+
+func (a *aead) NonceSize() int {
+  return a.nonce_size
+}
+
+
+func (a *aead) Overhead() int {
+  return a.overhead
+}
+
+
+func (a *aead) check_nonce(nonce []byte) {
+  if len(nonce) != a.nonce_size {
+    panic(fmt.Errorf(
+        "%w Got %d bytes, need %d.",
+        ErrInvalidNonceSize, len(nonce), a.nonce_size))
+  }
+}
+
+
+/** Appends the sealed plaintext to dst, as cipher.AEAD.Seal does. */
+func (a *aead) Seal(dst, nonce, plaintext, additional_data []byte) []byte {
+  a.check_nonce(nonce)
+  sealed, err := a.seal(
+      blocks.FromBytes(plaintext),
+      blocks.FromBytes(nonce),
+      blocks.FromBytes(additional_data))
+  if err != nil {
+    panic(err)
+  }
+  return append(dst, sealed.ToBytes()...)
+}
+
+
+/**
+ * Appends the opened plaintext to dst, or returns ErrAuthentication, as
+ * cipher.AEAD.Open does.
+ */
+func (a *aead) Open(
+    dst, nonce, ciphertext, additional_data []byte) ([]byte, error) {
+  a.check_nonce(nonce)
+  plaintext, err := a.open(
+      blocks.FromBytes(ciphertext),
+      blocks.FromBytes(nonce),
+      blocks.FromBytes(additional_data))
+  if err != nil {
+    return nil, err
+  }
+  return append(dst, plaintext.ToBytes()...), nil
+}
+
+
+/** Returns GCM with the standard 12-byte nonce, like cipher.NewGCM. */
+func (m *Modes) NewGcm() cipher.AEAD {
+  return &aead{
+      nonce_size: 12, overhead: GcmTagSize, seal: m.GcmSealE, open: m.GcmOpen}
+}
+
+
+/** Returns CCM with the given nonce (7 to 13 bytes) and tag sizes. */
+func (m *Modes) NewCcm(nonce_size int, tag_size int) cipher.AEAD {
+  if _, err := m.ccm_length_size(
+      0, blocks.RepeatByte(0, nonce_size), tag_size); err != nil {
+    panic(err)
+  }
+  return &aead{
+      nonce_size: nonce_size,
+      overhead: tag_size,
+      seal: func(
+          plaintext, nonce, additional_data *blocks.Blocks) (
+          *blocks.Blocks, error) {
+        return m.CcmSealE(plaintext, nonce, additional_data, tag_size)
+      },
+      open: func(
+          sealed, nonce, additional_data *blocks.Blocks) (
+          *blocks.Blocks, error) {
+        return m.CcmOpen(sealed, nonce, additional_data, tag_size)
+      }}
+}
+
+
+/** Returns EAX with a 16-byte nonce (EAX allows any length). */
+func (m *Modes) NewEax() cipher.AEAD {
+  return &aead{
+      nonce_size: 16, overhead: EaxTagSize, seal: m.EaxSealE, open: m.EaxOpen}
+}
+
+
+/** Returns AES-GCM-SIV with the 16 or 32 byte key. */
+func NewGcmSiv(key *blocks.Blocks) (cipher.AEAD, error) {
+  if key.Len() != 16 && key.Len() != 32 {
+    return nil, fmt.Errorf(
+        "%w GCM-SIV needs a 16 or 32 byte key, got %d.",
+        ErrInvalidKeySize, key.Len())
+  }
+  key = key.Copy()
+  return &aead{
+      nonce_size: GcmSivNonceSize,
+      overhead: GcmTagSize,
+      seal: func(
+          plaintext, nonce, additional_data *blocks.Blocks) (
+          *blocks.Blocks, error) {
+        return GcmSivSealE(plaintext, key, nonce, additional_data)
+      },
+      open: func(
+          sealed, nonce, additional_data *blocks.Blocks) (
+          *blocks.Blocks, error) {
+        return GcmSivOpen(sealed, key, nonce, additional_data)
+      }}, nil
+}
@@ -0,0 +1,167 @@
+package aes_modes
+
+import "bytes"
+import "crypto/aes"
+import "crypto/cipher"
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+/** Our AES Modes and the standard library's AES block, for the same key. */
+func adapter_ciphers(t *testing.T) (*Modes, cipher.Block) {
+  standard, err := aes.NewCipher(nist_key.ToBytes())
+  if err != nil {
+    t.Fatal(err)
+  }
+  return get_modes(nist_key), standard
+}
+
+
+/** Runs both streams over the input in awkward chunk sizes. */
+func compare_streams(
+    t *testing.T, name string, ours cipher.Stream, standard cipher.Stream) {
+  input := counting_text(1000).ToBytes()
+  got := make([]byte, len(input))
+  expected := make([]byte, len(input))
+  chunk := 1
+  for start := 0; start < len(input); start += chunk {
+    chunk += 3
+    end := start + chunk
+    if end > len(input) {
+      end = len(input)
+    }
+    ours.XORKeyStream(got[start:end], input[start:end])
+    standard.XORKeyStream(expected[start:end], input[start:end])
+  }
+  if !bytes.Equal(got, expected) {
+    t.Errorf("%s differs from crypto/cipher.", name)
+  }
+}
+
+
+func TestBlockModeMatchesStandardLibrary(t *testing.T) {
+  modes, standard := adapter_ciphers(t)
+  iv := nist_iv.ToBytes()
+  input := counting_text(16 * 20).ToBytes()
+  for _, pair := range []struct{
+    name string
+    ours, standard cipher.BlockMode
+  }{
+    {"CBC encrypt",
+     modes.NewCbcEncrypter(iv), cipher.NewCBCEncrypter(standard, iv)},
+    {"CBC decrypt",
+     modes.NewCbcDecrypter(iv), cipher.NewCBCDecrypter(standard, iv)},
+  } {
+    if pair.ours.BlockSize() != pair.standard.BlockSize() {
+      t.Errorf("%s: block size %d.", pair.name, pair.ours.BlockSize())
+    }
+    got := make([]byte, len(input))
+    expected := make([]byte, len(input))
+    // Several calls, to check the chaining carries over, one in place.
+    for _, span := range [][2]int{{0, 16}, {16, 80}, {80, 320}} {
+      copy(got[span[0]:span[1]], input[span[0]:span[1]])
+      pair.ours.CryptBlocks(got[span[0]:span[1]], got[span[0]:span[1]])
+      pair.standard.CryptBlocks(
+          expected[span[0]:span[1]], input[span[0]:span[1]])
+    }
+    if !bytes.Equal(got, expected) {
+      t.Errorf("%s differs from crypto/cipher.", pair.name)
+    }
+  }
+}
+
+
+func TestEcbBlockMode(t *testing.T) {
+  modes, _ := adapter_ciphers(t)
+  plaintext := nist_plaintext.ToBytes()
+  encrypted := make([]byte, len(plaintext))
+  modes.NewEcbEncrypter().CryptBlocks(encrypted, plaintext)
+  expected := EcbEncryptUnpadded(nist_plaintext, nist_key)
+  if !bytes.Equal(encrypted, expected.ToBytes()) {
+    t.Errorf("ECB BlockMode differs from EcbEncryptUnpadded.")
+  }
+  modes.NewEcbDecrypter().CryptBlocks(encrypted, encrypted)
+  if !bytes.Equal(encrypted, plaintext) {
+    t.Errorf("ECB BlockMode did not round-trip.")
+  }
+}
+
+
+func TestStreamMatchesStandardLibrary(t *testing.T) {
+  modes, standard := adapter_ciphers(t)
+  iv := nist_iv.ToBytes()
+  compare_streams(
+      t, "CTR",
+      modes.NewCtr(iv, CounterBigEndian128), cipher.NewCTR(standard, iv))
+  compare_streams(t, "OFB", modes.NewOfb(iv), cipher.NewOFB(standard, iv))
+  compare_streams(
+      t, "CFB encrypt",
+      modes.NewCfbEncrypter(iv), cipher.NewCFBEncrypter(standard, iv))
+  compare_streams(
+      t, "CFB decrypt",
+      modes.NewCfbDecrypter(iv), cipher.NewCFBDecrypter(standard, iv))
+}
+
+
+func TestAeadMatchesStandardLibrary(t *testing.T) {
+  modes, standard := adapter_ciphers(t)
+  standard_gcm, err := cipher.NewGCM(standard)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ours := modes.NewGcm()
+  if ours.NonceSize() != standard_gcm.NonceSize() ||
+      ours.Overhead() != standard_gcm.Overhead() {
+    t.Errorf(
+        "Nonce size %d and overhead %d differ.",
+        ours.NonceSize(), ours.Overhead())
+  }
+  nonce := blocks.RepeatByte(0x24, 12).ToBytes()
+  additional_data := []byte("header")
+  prefix := []byte("prefix")
+  for _, length := range []int{0, 1, 16, 100} {
+    plaintext := counting_text(length).ToBytes()
+    got := ours.Seal(prefix, nonce, plaintext, additional_data)
+    expected := standard_gcm.Seal(prefix, nonce, plaintext, additional_data)
+    if !bytes.Equal(got, expected) {
+      t.Errorf("Length %d: sealed output differs.", length)
+    }
+    opened, err := ours.Open(
+        nil, nonce, expected[len(prefix):], additional_data)
+    if err != nil || !bytes.Equal(opened, plaintext) {
+      t.Errorf("Length %d: did not open: %v.", length, err)
+    }
+  }
+}
+
+
+func TestAeadAdapters(t *testing.T) {
+  modes, _ := adapter_ciphers(t)
+  gcm_siv, err := NewGcmSiv(nist_key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for name, a := range map[string]cipher.AEAD{
+      "CCM": modes.NewCcm(13, 8),
+      "EAX": modes.NewEax(),
+      "GCM-SIV": gcm_siv,
+  } {
+    nonce := blocks.RepeatByte(0x24, a.NonceSize()).ToBytes()
+    plaintext := []byte("Ice, ice, baby.")
+    sealed := a.Seal(nil, nonce, plaintext, []byte("header"))
+    if len(sealed) != len(plaintext) + a.Overhead() {
+      t.Errorf("%s: sealed to %d bytes.", name, len(sealed))
+    }
+    opened, err := a.Open(nil, nonce, sealed, []byte("header"))
+    if err != nil || !bytes.Equal(opened, plaintext) {
+      t.Errorf("%s: did not round-trip: %v.", name, err)
+    }
+    sealed[0] ^= 1
+    if _, err := a.Open(nil, nonce, sealed, []byte("header")); !errors.Is(
+        err, ErrAuthentication) {
+      t.Errorf("%s: tampered input gave %v.", name, err)
+    }
+  }
+}
@@ -1,125 +1,410 @@
 /**
- * AES-based en/decryption modes.
+ * En/decryption modes of operation for block ciphers. The package-level
+ * functions use AES with the given key; WithCipher runs the same modes over
+ * any BlockCipher (DES, a toy cipher, reduced-round AES, ...).
  */
 
 package aes_modes
 
 import "crypto/aes"
-import "crypto/cipher"
-import "crypto/rand"
+import "errors"
 import "fmt"
 import "log"
-import "math/big"
 
 import "../blocks"
 
 
-func check_block_size(text *blocks.Blocks) {
-  if text.BlockSize() != aes.BlockSize {
-    panic(fmt.Sprintf(
-        "Input block size %d does not match AES block sizes %d.",
-        text.BlockSize(), aes.BlockSize))
+var ErrInvalidKeySize = errors.New("Invalid AES key size.")
+var ErrInvalidIvSize = errors.New("IV does not match the cipher block size.")
+var ErrPartialBlock = errors.New("Incomplete block.")
+var ErrBlockSize = errors.New("Cipher block size is unsupported by the mode.")
+
+
+/**
+ * A keyed block cipher for the modes to run over. crypto/cipher.Block (as from
+ * crypto/aes or crypto/des) satisfies this.
+ */
+type BlockCipher interface {
+  BlockSize() int
+  Encrypt(dst, src []byte)
+  Decrypt(dst, src []byte)
+}
+
+
+/** Modes of operation over one keyed block cipher. */
+type Modes struct {
+  block_cipher BlockCipher
+}
+
+
+func WithCipher(block_cipher BlockCipher) *Modes {
+  return &Modes{block_cipher: block_cipher}
+}
+
+
+func (m *Modes) BlockSize() int {
+  return m.block_cipher.BlockSize()
+}
+
+
+/** Returns the text, re-blocked (as a copy) to the cipher's block size. */
+func (m *Modes) in_blocks(text *blocks.Blocks) *blocks.Blocks {
+  if text.BlockSize() == m.BlockSize() {
+    return text
   }
+  rechunked := text.Copy()
+  rechunked.SetBlockSize(m.BlockSize())
+  return rechunked
 }
 
 
-func get_cipher(key *blocks.Blocks) cipher.Block {
+/** Returns new, empty Blocks using the cipher's block size. */
+func (m *Modes) new_blocks() *blocks.Blocks {
+  out := blocks.New()
+  out.SetBlockSize(m.BlockSize())
+  return out
+}
+
+
+/** Wraps output bytes as Blocks with the cipher's block size. */
+func (m *Modes) wrap_output(out []byte) *blocks.Blocks {
+  wrapped := blocks.FromBytes(out)
+  wrapped.SetBlockSize(m.BlockSize())
+  return wrapped
+}
+
+
+/** Checks that the input is a whole number of the cipher's blocks. */
+func (m *Modes) validate_full_blocks(text *blocks.Blocks) error {
+  if text.Len() % m.BlockSize() != 0 {
+    return fmt.Errorf(
+        "%w %d bytes is not a multiple of %d.",
+        ErrPartialBlock, text.Len(), m.BlockSize())
+  }
+  return nil
+}
+
+
+func (m *Modes) validate_iv(iv *blocks.Blocks) error {
+  if iv.Len() != m.BlockSize() {
+    return fmt.Errorf(
+        "%w Got %d bytes, need %d.", ErrInvalidIvSize, iv.Len(), m.BlockSize())
+  }
+  return nil
+}
+
+
+/**
+ * Returns Modes over AES with the given key, or an error wrapping
+ * ErrInvalidKeySize.
+ */
+func ForKey(key *blocks.Blocks) (*Modes, error) {
   aes_cipher, err := aes.NewCipher(key.ToBytes())
+  if err != nil {
+    return nil, fmt.Errorf(
+        "%w Got %d bytes, need 16, 24 or 32.", ErrInvalidKeySize, key.Len())
+  }
+  return WithCipher(aes_cipher), nil
+}
+
+
+func get_modes(key *blocks.Blocks) *Modes {
+  modes, err := ForKey(key)
+  if err != nil {
+    panic(err)
+  }
+  return modes
+}
+
+
+/** Unwraps the result of an error-returning mode, panicking on error. */
+func must(text *blocks.Blocks, err error) *blocks.Blocks {
   if err != nil {
     panic(err)
   }
-  return aes_cipher
+  return text
 }
 
 
 /**
- * Uses the AES block cipher in ECB mode to encrypt. Pads any partial.
+ * Uses the AES block cipher in ECB mode to encrypt. Adds PKCS#7 padding.
  * https://cryptopals.com/sets/1/challenges/7
  * https://en.wikipedia.org/wiki/Advanced_Encryption_Standard
  */
 func EcbEncrypt(plaintext *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
-  aes_cipher := get_cipher(key)
-  check_block_size(plaintext)
-  ciphertext := blocks.New()
-  for i := 0; i < plaintext.NumBlocks(); i++ {
-    plain_block := plaintext.BlockPadded(i).ToBytes()
-    cipher_block := make([]byte, aes.BlockSize)
-    aes_cipher.Encrypt(cipher_block, plain_block)
-    ciphertext.AppendBytes(cipher_block)
+  return must(EcbEncryptE(plaintext, key))
+}
+
+
+/** Like EcbEncrypt, but returns an error for a bad key. */
+func EcbEncryptE(
+    plaintext *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
   }
-  return ciphertext
+  return modes.EcbEncryptE(plaintext)
+}
+
+
+/**
+ * ECB-encrypts without padding. Input must be full blocks.
+ */
+func EcbEncryptUnpadded(
+    plaintext *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).EcbEncryptUnpadded(plaintext)
 }
 
 
 /**
- * AES-decrypts blocks. Input must match AES block size and be full blocks.
+ * AES-decrypts blocks and removes PKCS#7 padding. Input must be full blocks.
  */
 func EcbDecrypt(ciphertext *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
-  aes_cipher := get_cipher(key)
-  check_block_size(ciphertext)
-  plaintext := blocks.New()
-  for i := 0; i < ciphertext.NumBlocks(); i++ {
-    cipher_block := ciphertext.Block(i).ToBytes()
-    if len(cipher_block) < ciphertext.BlockSize() {
-      panic("Incomplete block.")
-    }
-    plain_block := make([]byte, aes.BlockSize)
-    aes_cipher.Decrypt(plain_block, cipher_block)
-    plaintext.AppendBytes(plain_block)
+  return must(EcbDecryptE(ciphertext, key))
+}
+
+
+/**
+ * Like EcbDecrypt, but returns an error for a bad key, partial blocks, or
+ * invalid padding (a *blocks.PaddingError).
+ */
+func EcbDecryptE(
+    ciphertext *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
   }
-  return plaintext
+  return modes.EcbDecryptE(ciphertext)
 }
 
 
 /**
- * Encrypt using CBC mode.
+ * AES-decrypts blocks, leaving any padding in place.
+ */
+func EcbDecryptUnpadded(
+    ciphertext *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).EcbDecryptUnpadded(ciphertext)
+}
+
+
+/**
+ * Encrypt using CBC mode. Adds PKCS#7 padding.
  * https://cryptopals.com/sets/2/challenges/10
  */
 func CbcEncrypt(
     plaintext *blocks.Blocks,
     key *blocks.Blocks,
     iv *blocks.Blocks) *blocks.Blocks {
-  check_block_size(plaintext)
-  ciphertext := blocks.New()
-  prev_cipher_block := iv
-  aes_cipher := get_cipher(key)
-  for i := 0; i < plaintext.NumBlocks(); i++ {
-    plain_block := plaintext.BlockPadded(i)
-    plain_block = plain_block.Xor(prev_cipher_block)
-    cipher_block := make([]byte, aes.BlockSize)
-    aes_cipher.Encrypt(cipher_block, plain_block.ToBytes())
-    ciphertext.AppendBytes(cipher_block)
-    prev_cipher_block = blocks.FromBytes(cipher_block)
+  return must(CbcEncryptE(plaintext, key, iv))
+}
+
+
+/** Like CbcEncrypt, but returns an error for a bad key or IV. */
+func CbcEncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
   }
-  return ciphertext
+  return modes.CbcEncryptE(plaintext, iv)
 }
 
 
+/**
+ * CBC-encrypts without padding. Input must be full blocks.
+ */
+func CbcEncryptUnpadded(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).CbcEncryptUnpadded(plaintext, iv)
+}
+
+
+/**
+ * Decrypt using CBC mode and remove PKCS#7 padding.
+ */
 func CbcDecrypt(
     ciphertext *blocks.Blocks,
     key *blocks.Blocks,
     iv *blocks.Blocks) *blocks.Blocks {
-  check_block_size(ciphertext)
-  plaintext := blocks.New()
-  prev_cipher_block := iv
-  aes_cipher := get_cipher(key)
-  for i := 0; i < ciphertext.NumBlocks(); i++ {
-    cipher_block := ciphertext.Block(i)
-    plain_block := make([]byte, aes.BlockSize)
-    aes_cipher.Decrypt(plain_block, cipher_block.ToBytes())
-    plaintext.Append(blocks.FromBytes(plain_block).Xor(prev_cipher_block))
-    prev_cipher_block = cipher_block
+  return must(CbcDecryptE(ciphertext, key, iv))
+}
+
+
+/**
+ * Like CbcDecrypt, but returns an error for a bad key or IV, partial blocks,
+ * or invalid padding (a *blocks.PaddingError).
+ */
+func CbcDecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
   }
-  return plaintext
+  return modes.CbcDecryptE(ciphertext, iv)
 }
 
 
-func rand_int(n int) int {
-  v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+/**
+ * CBC-decrypts, leaving any padding in place.
+ */
+func CbcDecryptUnpadded(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).CbcDecryptUnpadded(ciphertext, iv)
+}
+
+
+/** ECB-encrypts with this cipher, adding PKCS#7 padding. */
+func (m *Modes) EcbEncrypt(plaintext *blocks.Blocks) *blocks.Blocks {
+  return must(m.EcbEncryptE(plaintext))
+}
+
+
+func (m *Modes) EcbEncryptE(plaintext *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.ecb_encrypt(plaintext.PadPKCS7(m.BlockSize()))
+}
+
+
+func (m *Modes) EcbEncryptUnpadded(plaintext *blocks.Blocks) *blocks.Blocks {
+  return must(m.ecb_encrypt(plaintext))
+}
+
+
+func (m *Modes) ecb_encrypt(plaintext *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext = m.in_blocks(plaintext)
+  if err := m.validate_full_blocks(plaintext); err != nil {
+    return nil, err
+  }
+  ciphertext := make([]byte, plaintext.Len())
+  plaintext.EachBlock(func(i int, plain_block []byte) {
+    m.block_cipher.Encrypt(ciphertext[i * m.BlockSize():], plain_block)
+  })
+  return m.wrap_output(ciphertext), nil
+}
+
+
+/** ECB-decrypts with this cipher, removing PKCS#7 padding. */
+func (m *Modes) EcbDecrypt(ciphertext *blocks.Blocks) *blocks.Blocks {
+  return must(m.EcbDecryptE(ciphertext))
+}
+
+
+func (m *Modes) EcbDecryptE(ciphertext *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext, err := m.ecb_decrypt(ciphertext)
   if err != nil {
-    panic(err)
+    return nil, err
   }
-  return int(v.Int64())
+  return plaintext.UnpadPKCS7()
+}
+
+
+func (m *Modes) EcbDecryptUnpadded(ciphertext *blocks.Blocks) *blocks.Blocks {
+  return must(m.ecb_decrypt(ciphertext))
+}
+
+
+func (m *Modes) ecb_decrypt(ciphertext *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext = m.in_blocks(ciphertext)
+  if err := m.validate_full_blocks(ciphertext); err != nil {
+    return nil, err
+  }
+  plaintext := make([]byte, ciphertext.Len())
+  ciphertext.EachBlock(func(i int, cipher_block []byte) {
+    m.block_cipher.Decrypt(plaintext[i * m.BlockSize():], cipher_block)
+  })
+  return m.wrap_output(plaintext), nil
+}
+
+
+/** CBC-encrypts with this cipher, adding PKCS#7 padding. */
+func (m *Modes) CbcEncrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.CbcEncryptE(plaintext, iv))
+}
+
+
+func (m *Modes) CbcEncryptE(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.cbc_encrypt(plaintext.PadPKCS7(m.BlockSize()), iv)
+}
+
+
+func (m *Modes) CbcEncryptUnpadded(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.cbc_encrypt(plaintext, iv))
+}
+
+
+func (m *Modes) cbc_encrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext = m.in_blocks(plaintext)
+  if err := m.validate_full_blocks(plaintext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  ciphertext := make([]byte, plaintext.Len())
+  prev_cipher_block := iv.ToBytes()
+  plaintext.EachBlock(func(i int, plain_block []byte) {
+    cipher_block := ciphertext[i * m.BlockSize():(i + 1) * m.BlockSize()]
+    copy(cipher_block, plain_block)
+    blocks.XorInto(cipher_block, prev_cipher_block)
+    m.block_cipher.Encrypt(cipher_block, cipher_block)
+    prev_cipher_block = cipher_block
+  })
+  return m.wrap_output(ciphertext), nil
+}
+
+
+/** CBC-decrypts with this cipher, removing PKCS#7 padding. */
+func (m *Modes) CbcDecrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.CbcDecryptE(ciphertext, iv))
+}
+
+
+func (m *Modes) CbcDecryptE(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext, err := m.cbc_decrypt(ciphertext, iv)
+  if err != nil {
+    return nil, err
+  }
+  return plaintext.UnpadPKCS7()
+}
+
+
+func (m *Modes) CbcDecryptUnpadded(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.cbc_decrypt(ciphertext, iv))
+}
+
+
+func (m *Modes) cbc_decrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext = m.in_blocks(ciphertext)
+  if err := m.validate_full_blocks(ciphertext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  plaintext := make([]byte, ciphertext.Len())
+  prev_cipher_block := iv.ToBytes()
+  ciphertext.EachBlock(func(i int, cipher_block []byte) {
+    plain_block := plaintext[i * m.BlockSize():(i + 1) * m.BlockSize()]
+    m.block_cipher.Decrypt(plain_block, cipher_block)
+    blocks.XorInto(plain_block, prev_cipher_block)
+    prev_cipher_block = cipher_block
+  })
+  return m.wrap_output(plaintext), nil
 }
 
 
@@ -129,20 +414,23 @@ func rand_int(n int) int {
  * bytes
  */
 func RandomEncrypt(raw_plaintext *blocks.Blocks) *blocks.Blocks {
-  key := blocks.RandomBlock(aes.BlockSize)
-  plaintext := blocks.RandomBlock(5 + rand_int(5))
-  plaintext.Append(raw_plaintext)
-  plaintext.Append(blocks.RandomBlock(5 + rand_int(5)))
-  plaintext.SetBlockSize(aes.BlockSize)
-  if rand_int(2) > 0 {
+  return RandomEncryptFrom(blocks.CryptoRand, raw_plaintext)
+}
+
+
+/** Like RandomEncrypt, but takes all its random choices from r. */
+func RandomEncryptFrom(
+    r blocks.Rand, raw_plaintext *blocks.Blocks) *blocks.Blocks {
+  result := RandomEncryptOracle(r, raw_plaintext)
+  if result.Mode == ModeEcb {
     log.Printf(
-        "Encrypting %q with ECB using %s.", plaintext.ToString(), key.ToHex())
-    return EcbEncrypt(plaintext, key)
+        "Encrypting with ECB using %s (%d + %d random bytes).",
+        result.Key.ToHex(), result.PrefixLen, result.SuffixLen)
   } else {
-    iv := blocks.RandomBlock(aes.BlockSize)
     log.Printf(
-        "Encrypting %q with CBC using %s and %s.",
-        plaintext.ToString(), key.ToHex(), iv.ToHex())
-    return CbcEncrypt(plaintext, key, iv)
+        "Encrypting with CBC using %s and %s (%d + %d random bytes).",
+        result.Key.ToHex(), result.Iv.ToHex(),
+        result.PrefixLen, result.SuffixLen)
   }
+  return result.Ciphertext
 }
@@ -1,5 +1,9 @@
 package aes_modes
 
+import "bytes"
+import "crypto/cipher"
+import "crypto/des"
+import "errors"
 import "testing"
 
 import "../blocks"
@@ -10,14 +14,14 @@ func TestEcbRoundTrip(t *testing.T) {
   expected_ciphertext := blocks.FromBase64("gY0wCsoqhcmxyNhqH6YE3w==")
   key := blocks.FromString("YELLOW SUBMARINE")
 
-  cleartext := EcbDecrypt(expected_ciphertext, key)
+  cleartext := EcbDecryptUnpadded(expected_ciphertext, key)
   if cleartext.ToString() != expected_cleartext.ToString() {
     t.Errorf(
         "Expected decryption as %q, but got %q.",
         expected_cleartext.ToString(), cleartext.ToString())
   }
 
-  ciphertext := EcbEncrypt(expected_cleartext, key)
+  ciphertext := EcbEncryptUnpadded(expected_cleartext, key)
   if ciphertext.ToBase64() != expected_ciphertext.ToBase64() {
     t.Errorf(
         "Expected encryption as %q, but got %q.",
@@ -30,8 +34,10 @@ func TestEcbSize(t *testing.T) {
   in := blocks.FromString("is commonly used")
   key := blocks.FromString("YELLOW SUBMARINE")
   out := EcbEncrypt(in, key)
-  if in.Len() != out.Len() {
-    t.Errorf("Encryption changed length: %d to %d.", in.Len(), out.Len())
+  if in.Len() + 16 != out.Len() {
+    t.Errorf(
+        "Aligned encryption should add a padding block: %d to %d.",
+        in.Len(), out.Len())
   }
   round_trip := EcbDecrypt(out, key)
   if in.Len() != round_trip.Len() {
@@ -40,23 +46,375 @@ func TestEcbSize(t *testing.T) {
 }
 
 
+func TestEcbPaddedRoundTrip(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  for _, text := range [...]string{
+      "", "a", "fifteen bytes!!", "is commonly used", "seventeen bytes!!"} {
+    in := blocks.FromString(text)
+    out := EcbEncrypt(in, key)
+    if out.Len() % 16 != 0 || out.Len() <= in.Len() {
+      t.Errorf("Bad padded length %d for %q.", out.Len(), text)
+    }
+    round_trip := EcbDecrypt(out, key).ToString()
+    if round_trip != text {
+      t.Errorf("Expected round trip as %q, but got %q.", text, round_trip)
+    }
+  }
+}
+
+
+func TestEcbPkcs7MatchesOpenssl(t *testing.T) {
+  // echo -n 'pumpkin patch U!' | \
+  //   openssl enc -aes-128-ecb -nosalt -a -K 59454c4c4f57205355424d4152494e45
+  expected_ciphertext := blocks.FromBase64(
+      "gY0wCsoqhcmxyNhqH6YE32D6NnB+RfSZ26DyW5IjAaU=")
+  key := blocks.FromString("YELLOW SUBMARINE")
+  ciphertext := EcbEncrypt(blocks.FromString("pumpkin patch U!"), key)
+  if ciphertext.ToBase64() != expected_ciphertext.ToBase64() {
+    t.Errorf(
+        "Expected encryption as %q, but got %q.",
+        expected_ciphertext.ToBase64(), ciphertext.ToBase64())
+  }
+}
+
+
 func TestCbcRoundTrip(t *testing.T) {
   iv := blocks.FromString("YELLOW SUBMARINE")
   expected_cleartext := blocks.FromString("PUMPKIN PIE BOWL")
   expected_ciphertext := blocks.FromBase64("oJz6RDQ/SW+QKkYsdULvcg==")
   key := blocks.FromString("YELLOW SUBMARINE")
 
-  ciphertext := CbcEncrypt(expected_cleartext, key, iv)
+  ciphertext := CbcEncryptUnpadded(expected_cleartext, key, iv)
   if ciphertext.ToBase64() != expected_ciphertext.ToBase64() {
     t.Errorf(
         "Expected encryption as %q, but got %q.",
         expected_ciphertext.ToBase64(), ciphertext.ToBase64())
   }
 
-  cleartext := CbcDecrypt(ciphertext, key, iv)
+  cleartext := CbcDecryptUnpadded(ciphertext, key, iv)
   if cleartext.ToString() != expected_cleartext.ToString() {
     t.Errorf(
         "Expected decryption as %q, but got %q.",
         expected_cleartext.ToString(), cleartext.ToString())
   }
 }
+
+
+func TestCbcPaddedRoundTrip(t *testing.T) {
+  iv := blocks.FromString("YELLOW SUBMARINE")
+  key := blocks.FromString("YELLOW SUBMARINE")
+  for _, text := range [...]string{
+      "", "PUMPKIN", "PUMPKIN PIE BOWL", "PUMPKIN PIE BOWL, WITH CREAM"} {
+    in := blocks.FromString(text)
+    out := CbcEncrypt(in, key, iv)
+    if out.Len() != (in.Len() / 16 + 1) * 16 {
+      t.Errorf("Bad padded length %d for %q.", out.Len(), text)
+    }
+    round_trip := CbcDecrypt(out, key, iv).ToString()
+    if round_trip != text {
+      t.Errorf("Expected round trip as %q, but got %q.", text, round_trip)
+    }
+  }
+}
+
+
+func TestInvalidKeySize(t *testing.T) {
+  _, err := EcbEncryptE(
+      blocks.FromString("pumpkin"), blocks.FromString("YELLOW SUB"))
+  if !errors.Is(err, ErrInvalidKeySize) {
+    t.Errorf("Expected ErrInvalidKeySize but got %v.", err)
+  }
+}
+
+
+func TestPartialBlock(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.FromString("YELLOW SUBMARINE")
+  ciphertext := blocks.FromString("seventeen bytes!!")
+  if _, err := EcbDecryptE(ciphertext, key); !errors.Is(err, ErrPartialBlock) {
+    t.Errorf("Expected ErrPartialBlock from ECB but got %v.", err)
+  }
+  _, err := CbcDecryptE(ciphertext, key, iv)
+  if !errors.Is(err, ErrPartialBlock) {
+    t.Errorf("Expected ErrPartialBlock from CBC but got %v.", err)
+  }
+}
+
+
+func TestInvalidIvSize(t *testing.T) {
+  _, err := CbcEncryptE(
+      blocks.FromString("pumpkin"),
+      blocks.FromString("YELLOW SUBMARINE"),
+      blocks.FromString("YELLOW"))
+  if !errors.Is(err, ErrInvalidIvSize) {
+    t.Errorf("Expected ErrInvalidIvSize but got %v.", err)
+  }
+}
+
+
+func TestBadPadding(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  ciphertext := EcbEncryptUnpadded(blocks.FromString("PUMPKIN PIE BOWL"), key)
+  _, err := EcbDecryptE(ciphertext, key)
+  if _, ok := err.(*blocks.PaddingError); !ok {
+    t.Errorf("Expected a PaddingError but got %v.", err)
+  }
+}
+
+
+// NIST SP 800-38A, Appendix F: the AES-128 key, IV and plaintext shared by
+// the mode examples.
+var nist_key = blocks.FromHex("2b7e151628aed2a6abf7158809cf4f3c")
+var nist_iv = blocks.FromHex("000102030405060708090a0b0c0d0e0f")
+var nist_plaintext = blocks.FromHex(
+    "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+    "30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+
+
+func check_vector(
+    t *testing.T,
+    name string,
+    encrypt func(*blocks.Blocks, *blocks.Blocks, *blocks.Blocks) *blocks.Blocks,
+    decrypt func(*blocks.Blocks, *blocks.Blocks, *blocks.Blocks) *blocks.Blocks,
+    plaintext *blocks.Blocks,
+    expected_hex string) {
+  ciphertext := encrypt(plaintext, nist_key, nist_iv)
+  if ciphertext.ToHex() != expected_hex {
+    t.Errorf(
+        "%s: expected encryption as %s, but got %s.",
+        name, expected_hex, ciphertext.ToHex())
+  }
+  round_trip := decrypt(ciphertext, nist_key, nist_iv)
+  if !blocks.Equal(round_trip, plaintext) {
+    t.Errorf(
+        "%s: expected decryption as %s, but got %s.",
+        name, plaintext.ToHex(), round_trip.ToHex())
+  }
+}
+
+
+func TestOfbNist(t *testing.T) {
+  // F.4.1 OFB-AES128.Encrypt
+  check_vector(t, "OFB", OfbEncrypt, OfbDecrypt, nist_plaintext,
+      "3b3fd92eb72dad20333449f8e83cfb4a7789508d16918f03f53c52dac54ed825" +
+      "9740051e9c5fecf64344f7a82260edcc304c6528f659c77866a510d9c1d6ae5e")
+  // A partial final block uses a prefix of the keystream.
+  check_vector(t, "OFB partial", OfbEncrypt, OfbDecrypt,
+      blocks.FromBytes(nist_plaintext.ToBytes()[:10]),
+      "3b3fd92eb72dad203334")
+}
+
+
+func TestCfbNist(t *testing.T) {
+  // F.3.13 CFB128-AES128.Encrypt
+  check_vector(t, "CFB-128", CfbEncrypt, CfbDecrypt, nist_plaintext,
+      "3b3fd92eb72dad20333449f8e83cfb4ac8a64537a0b3a93fcde3cdad9f1ce58b" +
+      "26751f67a3cbb140b1808cf187a4f4dfc04b05357c5d1c0eeac4c66f9ff7f2e6")
+}
+
+
+func TestCfb8Nist(t *testing.T) {
+  // F.3.7 CFB8-AES128.Encrypt
+  check_vector(t, "CFB-8", Cfb8Encrypt, Cfb8Decrypt,
+      blocks.FromHex("6bc1bee22e409f96e93d7e117393172aae2d"),
+      "3b79424c9c0dd436bace9e0ed4586a4f32b9")
+}
+
+
+func TestCfbErrorPropagation(t *testing.T) {
+  ciphertext := CfbEncrypt(nist_plaintext, nist_key, nist_iv)
+  corrupted := ciphertext.Copy().ToBytes()
+  corrupted[3] ^= 0x01
+  plaintext := CfbDecrypt(blocks.FromBytes(corrupted), nist_key, nist_iv)
+  for i := 0; i < plaintext.NumBlocks(); i++ {
+    differs := !blocks.Equal(plaintext.Block(i), nist_plaintext.Block(i))
+    if differs != (i <= 1) {
+      t.Errorf(
+          "Block %d should differ: %t, but differs: %t.", i, i <= 1, differs)
+    }
+  }
+}
+
+
+/** A toy 8-byte block cipher: add the key bytewise and rotate left a byte. */
+type toy_cipher struct {
+  key [8]byte
+}
+
+
+func (c *toy_cipher) BlockSize() int {
+  return 8
+}
+
+
+func (c *toy_cipher) Encrypt(dst, src []byte) {
+  var out [8]byte
+  for i := range out {
+    out[i] = src[(i + 1) % 8] + c.key[i]
+  }
+  copy(dst, out[:])
+}
+
+
+func (c *toy_cipher) Decrypt(dst, src []byte) {
+  var out [8]byte
+  for i := range out {
+    out[(i + 1) % 8] = src[i] - c.key[i]
+  }
+  copy(dst, out[:])
+}
+
+
+func TestToyCipherModes(t *testing.T) {
+  modes := WithCipher(&toy_cipher{key: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}})
+  plaintext := blocks.FromString("Ice, Ice, baby. Too cold.")
+  iv := blocks.FromString("8 bytes!")
+  ciphertext := modes.CbcEncrypt(plaintext, iv)
+  if ciphertext.Len() != 32 || ciphertext.BlockSize() != 8 {
+    t.Errorf(
+        "Expected 32 bytes in blocks of 8, got %d in blocks of %d.",
+        ciphertext.Len(), ciphertext.BlockSize())
+  }
+  round_trip := modes.CbcDecrypt(ciphertext, iv)
+  if !blocks.Equal(round_trip, plaintext) {
+    t.Errorf("CBC round trip gave %q.", round_trip.ToString())
+  }
+  round_trip = modes.EcbDecrypt(modes.EcbEncrypt(plaintext))
+  if !blocks.Equal(round_trip, plaintext) {
+    t.Errorf("ECB round trip gave %q.", round_trip.ToString())
+  }
+  _, err := modes.CbcEncryptE(plaintext, nist_iv)
+  if !errors.Is(err, ErrInvalidIvSize) {
+    t.Errorf("Expected ErrInvalidIvSize for a 16-byte IV, got %v.", err)
+  }
+}
+
+
+func TestDesModesMatchStandardLibrary(t *testing.T) {
+  des_cipher, _ := des.NewCipher([]byte("8bytekey"))
+  triple_des_cipher, _ := des.NewTripleDESCipher(
+      []byte("twenty-four byte key!!!!"))
+  plaintext := blocks.FromString("We all live in a yellow submarine")
+  iv := blocks.FromString("DES IV!!")
+  for _, block_cipher := range []cipher.Block{des_cipher, triple_des_cipher} {
+    modes := WithCipher(block_cipher)
+
+    padded := plaintext.PadPKCS7(8).ToBytes()
+    expected := make([]byte, len(padded))
+    cipher.NewCBCEncrypter(block_cipher, iv.ToBytes()).CryptBlocks(
+        expected, padded)
+    actual := modes.CbcEncrypt(plaintext, iv)
+    if !bytes.Equal(actual.ToBytes(), expected) {
+      t.Errorf("CBC: expected %x but got %s.", expected, actual.ToHex())
+    }
+
+    expected = make([]byte, plaintext.Len())
+    cipher.NewCTR(block_cipher, iv.ToBytes()).XORKeyStream(
+        expected, plaintext.ToBytes())
+    actual = modes.CtrEncrypt(plaintext, iv, CounterBigEndian128)
+    if !bytes.Equal(actual.ToBytes(), expected) {
+      t.Errorf("CTR: expected %x but got %s.", expected, actual.ToHex())
+    }
+  }
+}
+
+
+func TestAllKeySizesNist(t *testing.T) {
+  // NIST SP 800-38A F.1 (ECB) and F.2 (CBC), for AES-128, -192 and -256.
+  for _, vector := range []struct{ key, ecb, cbc string }{
+      {"2b7e151628aed2a6abf7158809cf4f3c",
+       "3ad77bb40d7a3660a89ecaf32466ef97f5d3d58503b9699de785895a96fdbaaf" +
+       "43b1cd7f598ece23881b00e3ed0306887b0c785e27e8ad3f8223207104725dd4",
+       "7649abac8119b246cee98e9b12e9197d5086cb9b507219ee95db113a917678b2" +
+       "73bed6b8e3c1743b7116e69e222295163ff1caa1681fac09120eca307586e1a7"},
+      {"8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b",
+       "bd334f1d6e45f25ff712a214571fa5cc974104846d0ad3ad7734ecb3ecee4eef" +
+       "ef7afd2270e2e60adce0ba2face6444e9a4b41ba738d6c72fb16691603c18e0e",
+       "4f021db243bc633d7178183a9fa071e8b4d9ada9ad7dedf4e5e738763f69145a" +
+       "571b242012fb7ae07fa9baac3df102e008b0e27988598881d920a9e64f5615cd"},
+      {"603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4",
+       "f3eed1bdb5d2a03c064b5a7e3db181f8591ccb10d410ed26dc5ba74a31362870" +
+       "b6ed21b99ca6f4f9f153e7b1beafed1d23304b7a39f9f3ff067d8d8f9e24ecc7",
+       "f58c4c04d6e5f1ba779eabfb5f7bfbd69cfc4e967edb808d679f777bc6702c7d" +
+       "39f23369a9d9bacfa530e26304231461b2eb05e2c39be9fcda6c19078c6a9d1b"}} {
+    key := blocks.FromHex(vector.key)
+    ecb := EcbEncryptUnpadded(nist_plaintext, key)
+    if ecb.ToHex() != vector.ecb {
+      t.Errorf(
+          "ECB, %d-bit key: expected %s but got %s.",
+          key.Len() * 8, vector.ecb, ecb.ToHex())
+    }
+    if !blocks.Equal(EcbDecryptUnpadded(ecb, key), nist_plaintext) {
+      t.Errorf("ECB, %d-bit key: round trip failed.", key.Len() * 8)
+    }
+    cbc := CbcEncryptUnpadded(nist_plaintext, key, nist_iv)
+    if cbc.ToHex() != vector.cbc {
+      t.Errorf(
+          "CBC, %d-bit key: expected %s but got %s.",
+          key.Len() * 8, vector.cbc, cbc.ToHex())
+    }
+    if !blocks.Equal(CbcDecryptUnpadded(cbc, key, nist_iv), nist_plaintext) {
+      t.Errorf("CBC, %d-bit key: round trip failed.", key.Len() * 8)
+    }
+    padded := EcbEncrypt(blocks.FromString("odd length"), key)
+    if EcbDecrypt(padded, key).ToString() != "odd length" {
+      t.Errorf("ECB, %d-bit key: padded round trip failed.", key.Len() * 8)
+    }
+    padded = CbcEncrypt(blocks.FromString("odd length"), key, nist_iv)
+    if CbcDecrypt(padded, key, nist_iv).ToString() != "odd length" {
+      t.Errorf("CBC, %d-bit key: padded round trip failed.", key.Len() * 8)
+    }
+  }
+}
+
+
+func TestRandomEncryptFromSeed(t *testing.T) {
+  plaintext := blocks.RepeatByte('a', 48)
+  first := RandomEncryptFrom(blocks.NewSeededRand(11), plaintext)
+  second := RandomEncryptFrom(blocks.NewSeededRand(11), plaintext)
+  if !blocks.Equal(first, second) {
+    t.Errorf("The same seed gave %s and %s.", first.ToHex(), second.ToHex())
+  }
+}
+
+
+/** Serial modes, reporting allocations, which should not grow per block. */
+func BenchmarkEcbEncrypt(b *testing.B) {
+  text := benchmark_input(b)
+  b.SetBytes(int64(text.Len()))
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    EcbEncrypt(text, benchmark_key)
+  }
+}
+
+
+func BenchmarkOfb(b *testing.B) {
+  text := benchmark_input(b)
+  b.SetBytes(int64(text.Len()))
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    OfbEncrypt(text, benchmark_key, benchmark_iv)
+  }
+}
+
+
+func BenchmarkCfbEncrypt(b *testing.B) {
+  text := benchmark_input(b)
+  b.SetBytes(int64(text.Len()))
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    CfbEncrypt(text, benchmark_key, benchmark_iv)
+  }
+}
+
+
+func BenchmarkGcmSeal(b *testing.B) {
+  text := benchmark_input(b)
+  nonce := blocks.RepeatByte(0x42, 12)
+  b.SetBytes(int64(text.Len()))
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    GcmSeal(text, benchmark_key, nonce, blocks.New())
+  }
+}
@@ -0,0 +1,262 @@
+/**
+ * Counter with CBC-MAC (CCM, RFC 3610), as in 802.15.4, Bluetooth LE and
+ * WPA2's CCMP. The tag is a CBC-MAC over a header block, the associated data
+ * and the plaintext; encryption is CTR.
+ * https://tools.ietf.org/html/rfc3610
+ */
+
+package aes_modes
+
+import "crypto/subtle"
+import "errors"
+import "fmt"
+
+import "../blocks"
+
+
+var ErrInvalidTagSize = errors.New("Unsupported tag size.")
+
+
+/**
+ * Checks the CCM parameters, and returns L, the size of the message length
+ * field (and block counter). The nonce is the rest of a 15-byte field, so
+ * 7 to 13 byte nonces give L of 8 down to 2.
+ */
+func (m *Modes) ccm_length_size(
+    text_size int, nonce *blocks.Blocks, tag_size int) (int, error) {
+  if m.BlockSize() != gcm_block_size {
+    return 0, fmt.Errorf(
+        "%w CCM needs a %d-byte block cipher, got %d.",
+        ErrBlockSize, gcm_block_size, m.BlockSize())
+  }
+  if tag_size < 4 || tag_size > 16 || tag_size % 2 != 0 {
+    return 0, fmt.Errorf(
+        "%w CCM tags are 4, 6, ..., 16 bytes, got %d.",
+        ErrInvalidTagSize, tag_size)
+  }
+  if nonce.Len() < 7 || nonce.Len() > 13 {
+    return 0, fmt.Errorf(
+        "%w CCM nonces are 7 to 13 bytes, got %d.",
+        ErrInvalidNonceSize, nonce.Len())
+  }
+  length_size := 15 - nonce.Len()
+  if length_size < 8 && uint64(text_size) >> uint(8 * length_size) != 0 {
+    return 0, fmt.Errorf(
+        "%w A %d byte message needs a nonce shorter than %d bytes.",
+        ErrInvalidNonceSize, text_size, nonce.Len())
+  }
+  return length_size, nil
+}
+
+
+/** Writes value big-endian into the whole of out. */
+func put_big_endian(out []byte, value uint64) {
+  for i := len(out) - 1; i >= 0; i-- {
+    out[i] = byte(value)
+    value >>= 8
+  }
+}
+
+
+/** Pads with zeros to a whole number of blocks. */
+func zero_pad(data *blocks.Blocks, block_size int) {
+  if data.Len() % block_size != 0 {
+    data.AppendBytes(make([]byte, block_size - data.Len() % block_size))
+  }
+}
+
+
+/**
+ * Returns the CBC-MAC input: the B_0 flags/nonce/length block, the encoded
+ * associated data length and associated data, and the message, each padded.
+ */
+func ccm_mac_input(
+    nonce []byte,
+    additional_data []byte,
+    plaintext []byte,
+    tag_size int,
+    length_size int) *blocks.Blocks {
+  b0 := make([]byte, gcm_block_size)
+  b0[0] = byte((tag_size - 2) / 2 << 3 | (length_size - 1))
+  if len(additional_data) > 0 {
+    b0[0] |= 0x40
+  }
+  copy(b0[1:], nonce)
+  put_big_endian(b0[1 + len(nonce):], uint64(len(plaintext)))
+  input := blocks.FromBytes(b0)
+
+  if len(additional_data) > 0 {
+    var encoded_length []byte
+    switch {
+    case len(additional_data) < 0xff00:
+      encoded_length = make([]byte, 2)
+      put_big_endian(encoded_length, uint64(len(additional_data)))
+    case uint64(len(additional_data)) < 1 << 32:
+      encoded_length = []byte{0xff, 0xfe, 0, 0, 0, 0}
+      put_big_endian(encoded_length[2:], uint64(len(additional_data)))
+    default:
+      encoded_length = []byte{0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+      put_big_endian(encoded_length[2:], uint64(len(additional_data)))
+    }
+    input.AppendBytes(encoded_length)
+    input.AppendBytes(additional_data)
+    zero_pad(input, gcm_block_size)
+  }
+  input.AppendBytes(plaintext)
+  zero_pad(input, gcm_block_size)
+  return input
+}
+
+
+/** Returns the counter block A_i: flags, nonce, and the counter i. */
+func ccm_counter(nonce []byte, length_size int, i uint64) *blocks.Blocks {
+  a := make([]byte, gcm_block_size)
+  a[0] = byte(length_size - 1)
+  copy(a[1:], nonce)
+  put_big_endian(a[1 + len(nonce):], i)
+  return blocks.FromBytes(a)
+}
+
+
+/**
+ * Encrypts and authenticates the plaintext with AES-CCM, and authenticates the
+ * additional data. The tag is tag_size bytes (even, 4 to 16); the nonce length
+ * (7 to 13 bytes) sets the size of the length field, so limits the message
+ * length. Returns the ciphertext followed by the tag.
+ */
+func CcmSeal(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) *blocks.Blocks {
+  return must(CcmSealE(plaintext, key, nonce, additional_data, tag_size))
+}
+
+
+/** Like CcmSeal, but returns an error for a bad key, nonce or tag size. */
+func CcmSealE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CcmSealE(plaintext, nonce, additional_data, tag_size)
+}
+
+
+/**
+ * Decrypts and verifies (in constant time) output from CcmSeal. Returns
+ * ErrAuthentication, and no plaintext, if verification fails.
+ */
+func CcmOpen(
+    sealed *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CcmOpen(sealed, nonce, additional_data, tag_size)
+}
+
+
+/** CCM-encrypts with this (128-bit block) cipher. */
+func (m *Modes) CcmSeal(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) *blocks.Blocks {
+  return must(m.CcmSealE(plaintext, nonce, additional_data, tag_size))
+}
+
+
+func (m *Modes) CcmSealE(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) (*blocks.Blocks, error) {
+  length_size, err := m.ccm_length_size(plaintext.Len(), nonce, tag_size)
+  if err != nil {
+    return nil, err
+  }
+  tag := m.ccm_mac(
+      nonce, additional_data, plaintext.ToBytes(), tag_size, length_size)
+  sealed, err := m.CtrEncryptE(
+      plaintext,
+      ccm_counter(nonce.ToBytes(), length_size, 1),
+      CounterBigEndian128)
+  if err != nil {
+    return nil, err
+  }
+  sealed.AppendBytes(m.ccm_encrypt_tag(tag, nonce.ToBytes(), length_size))
+  return sealed, nil
+}
+
+
+func (m *Modes) CcmOpen(
+    sealed *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    tag_size int) (*blocks.Blocks, error) {
+  if sealed.Len() < tag_size {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for a tag.", ErrAuthentication, sealed.Len())
+  }
+  split := sealed.Len() - tag_size
+  length_size, err := m.ccm_length_size(split, nonce, tag_size)
+  if err != nil {
+    return nil, err
+  }
+  plaintext, err := m.CtrEncryptE(
+      blocks.FromBytes(sealed.ToBytes()[:split]),
+      ccm_counter(nonce.ToBytes(), length_size, 1),
+      CounterBigEndian128)
+  if err != nil {
+    return nil, err
+  }
+  tag := m.ccm_mac(
+      nonce, additional_data, plaintext.ToBytes(), tag_size, length_size)
+  expected := m.ccm_encrypt_tag(tag, nonce.ToBytes(), length_size)
+  if subtle.ConstantTimeCompare(expected, sealed.ToBytes()[split:]) != 1 {
+    return nil, ErrAuthentication
+  }
+  return plaintext, nil
+}
+
+
+/** Returns the first tag_size bytes of the CBC-MAC (zero IV) of the input. */
+func (m *Modes) ccm_mac(
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    plaintext []byte,
+    tag_size int,
+    length_size int) []byte {
+  input := ccm_mac_input(
+      nonce.ToBytes(),
+      additional_data.ToBytes(),
+      plaintext,
+      tag_size,
+      length_size)
+  chained := must(m.cbc_encrypt(input, blocks.RepeatByte(0, gcm_block_size)))
+  return chained.Block(chained.NumBlocks() - 1).ToBytes()[:tag_size]
+}
+
+
+/** Encrypts the tag with the keystream block for counter 0. */
+func (m *Modes) ccm_encrypt_tag(
+    tag []byte, nonce []byte, length_size int) []byte {
+  s0 := make([]byte, gcm_block_size)
+  m.block_cipher.Encrypt(s0, ccm_counter(nonce, length_size, 0).ToBytes())
+  encrypted := make([]byte, len(tag))
+  for i := range tag {
+    encrypted[i] = tag[i] ^ s0[i]
+  }
+  return encrypted
+}
@@ -0,0 +1,109 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestCcmRfc3610(t *testing.T) {
+  // Packet vectors 1-4: an 8-byte tag and 13-byte nonce (L = 2).
+  key := blocks.FromHex("c0c1c2c3c4c5c6c7c8c9cacbcccdcecf")
+  for i, vector := range []struct{
+      nonce string
+      header_size, packet_size int
+      sealed string
+  }{
+      {"00000003020100a0a1a2a3a4a5", 8, 31,
+       "588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0"},
+      {"00000004030201a0a1a2a3a4a5", 8, 32,
+       "72c91a36e135f8cf291ca894085c87e3cc15c439c9e43a3ba091d56e10400916"},
+      {"00000005040302a0a1a2a3a4a5", 8, 33,
+       "51b1e5f44a197d1da46b0f8e2d282ae871e838bb64da859657" +
+       "4adaa76fbd9fb0c5"},
+      {"00000006050403a0a1a2a3a4a5", 12, 31,
+       "a28c6865939a9a79faaa5c4c2a9d4a91cdac8c96c861b9c9e61ef1"},
+  } {
+    packet := make([]byte, vector.packet_size)
+    for j := range packet {
+      packet[j] = byte(j)
+    }
+    nonce := blocks.FromHex(vector.nonce)
+    header := blocks.FromBytes(packet[:vector.header_size])
+    plaintext := blocks.FromBytes(packet[vector.header_size:])
+    sealed := CcmSeal(plaintext, key, nonce, header, 8)
+    if sealed.ToHex() != vector.sealed {
+      t.Errorf(
+          "Packet %d: expected %s but got %s.",
+          i + 1, vector.sealed, sealed.ToHex())
+    }
+    opened, err := CcmOpen(sealed, key, nonce, header, 8)
+    if err != nil || !blocks.Equal(opened, plaintext) {
+      t.Errorf("Packet %d did not round-trip: %v.", i + 1, err)
+    }
+  }
+}
+
+
+func TestCcmParameters(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 16)
+  plaintext := blocks.FromString("Ice, ice, baby.")
+  ad := blocks.FromString("header")
+  for _, tag_size := range []int{4, 6, 8, 10, 12, 14, 16} {
+    for nonce_size := 7; nonce_size <= 13; nonce_size++ {
+      nonce := blocks.RepeatByte(0x24, nonce_size)
+      sealed := CcmSeal(plaintext, key, nonce, ad, tag_size)
+      if sealed.Len() != plaintext.Len() + tag_size {
+        t.Errorf(
+            "Tag size %d, nonce size %d sealed to %d bytes.",
+            tag_size, nonce_size, sealed.Len())
+      }
+      opened, err := CcmOpen(sealed, key, nonce, ad, tag_size)
+      if err != nil || !blocks.Equal(opened, plaintext) {
+        t.Errorf(
+            "Tag size %d, nonce size %d did not round-trip: %v.",
+            tag_size, nonce_size, err)
+      }
+    }
+  }
+  nonce := blocks.RepeatByte(0x24, 13)
+  for _, tag_size := range []int{0, 2, 5, 18} {
+    if _, err := CcmSealE(plaintext, key, nonce, ad, tag_size); !errors.Is(
+        err, ErrInvalidTagSize) {
+      t.Errorf("Tag size %d gave %v.", tag_size, err)
+    }
+  }
+  for _, nonce_size := range []int{6, 14} {
+    nonce := blocks.RepeatByte(0x24, nonce_size)
+    if _, err := CcmSealE(plaintext, key, nonce, ad, 8); !errors.Is(
+        err, ErrInvalidNonceSize) {
+      t.Errorf("Nonce size %d gave %v.", nonce_size, err)
+    }
+  }
+  // With a 13-byte nonce, L = 2 bytes cannot hold a 64KiB length.
+  long := blocks.RepeatByte(0, 1 << 16)
+  if _, err := CcmSealE(long, key, nonce, ad, 8); !errors.Is(
+      err, ErrInvalidNonceSize) {
+    t.Errorf("Over-long message gave %v.", err)
+  }
+}
+
+
+func TestCcmRejectsTampering(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 16)
+  nonce := blocks.RepeatByte(0x24, 13)
+  ad := blocks.FromString("header")
+  sealed := CcmSeal(blocks.FromString("Ice, ice, baby."), key, nonce, ad, 8)
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 1
+    _, err := CcmOpen(blocks.FromBytes(tampered), key, nonce, ad, 8)
+    if !errors.Is(err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  _, err := CcmOpen(sealed, key, nonce, blocks.FromString("Header"), 8)
+  if !errors.Is(err, ErrAuthentication) {
+    t.Errorf("Changed associated data gave %v.", err)
+  }
+}
@@ -0,0 +1,88 @@
+/**
+ * CMAC (RFC 4493) over a 128-bit block cipher. This is the core of the mac
+ * package's Cmac, and of S2V in AES-SIV.
+ */
+
+package aes_modes
+
+import "fmt"
+
+import "../blocks"
+
+
+/** Doubles a block in GF(2^128): shift left, reducing by 0x87 on carry. */
+func cmac_double(block []byte) []byte {
+  doubled := make([]byte, len(block))
+  carry := byte(0)
+  for i := len(block) - 1; i >= 0; i-- {
+    doubled[i] = block[i] << 1 | carry
+    carry = block[i] >> 7
+  }
+  if carry != 0 {
+    doubled[len(doubled) - 1] ^= 0x87
+  }
+  return doubled
+}
+
+
+func (m *Modes) check_cmac_block_size() {
+  if m.BlockSize() != gcm_block_size {
+    panic(fmt.Sprintf(
+        "CMAC needs a %d-byte block cipher, got %d.",
+        gcm_block_size, m.BlockSize()))
+  }
+}
+
+
+/** Returns the CMAC subkeys K1 and K2, from the zero block's encryption. */
+func (m *Modes) CmacSubkeys() (*blocks.Blocks, *blocks.Blocks) {
+  k1, k2 := m.cmac_subkeys()
+  return blocks.FromBytes(k1), blocks.FromBytes(k2)
+}
+
+
+func (m *Modes) cmac_subkeys() ([]byte, []byte) {
+  m.check_cmac_block_size()
+  l := make([]byte, m.BlockSize())
+  m.block_cipher.Encrypt(l, l)
+  k1 := cmac_double(l)
+  return k1, cmac_double(k1)
+}
+
+
+/**
+ * Returns the CMAC of the message: CBC-MAC with a zero IV, with the last block
+ * masked by K1 if it is complete, or 10* padded and masked by K2 if not.
+ */
+func (m *Modes) Cmac(message *blocks.Blocks) *blocks.Blocks {
+  return blocks.FromBytes(m.cmac(message.ToBytes()))
+}
+
+
+func (m *Modes) cmac(data []byte) []byte {
+  k1, k2 := m.cmac_subkeys()
+  block_size := m.BlockSize()
+  last_start := 0
+  if len(data) > 0 {
+    last_start = (len(data) - 1) / block_size * block_size
+  }
+  last := make([]byte, block_size)
+  copy(last, data[last_start:])
+  mask := k1
+  if len(data) == 0 || len(data) % block_size != 0 {
+    last[len(data) - last_start] = 0x80
+    mask = k2
+  }
+  state := make([]byte, block_size)
+  for start := 0; start < last_start; start += block_size {
+    for i := range state {
+      state[i] ^= data[start + i]
+    }
+    m.block_cipher.Encrypt(state, state)
+  }
+  for i := range state {
+    state[i] ^= last[i] ^ mask[i]
+  }
+  m.block_cipher.Encrypt(state, state)
+  return state
+}
@@ -0,0 +1,209 @@
+/**
+ * Counter (CTR) mode, which turns the block cipher into a stream cipher.
+ * https://cryptopals.com/sets/3/challenges/18
+ */
+
+package aes_modes
+
+import "encoding/binary"
+import "errors"
+import "fmt"
+
+import "../blocks"
+
+
+var ErrInvalidNonceSize = errors.New("Nonce size does not match the counter.")
+var ErrCounterFormat = errors.New("Unknown counter format.")
+
+
+/** How the nonce and the block counter are laid out in each counter block. */
+type CounterFormat int
+
+const (
+  // The nonce, then a 64-bit little-endian block count starting at 0. With
+  // AES this is cryptopals' 64-bit nonce and 64-bit count.
+  CounterLittleEndian64 CounterFormat = iota
+  // The nonce is the whole initial counter block (128 bits for AES),
+  // incremented as a big-endian integer (as in NIST SP 800-38A).
+  CounterBigEndian128
+)
+
+
+func (f CounterFormat) validate() error {
+  if f != CounterLittleEndian64 && f != CounterBigEndian128 {
+    return fmt.Errorf("%w Got %d.", ErrCounterFormat, int(f))
+  }
+  return nil
+}
+
+
+func (f CounterFormat) nonce_size(block_size int) int {
+  switch f {
+  case CounterLittleEndian64:
+    return block_size - 8
+  case CounterBigEndian128:
+    return block_size
+  default:
+    panic(fmt.Sprintf("Unknown counter format %d.", f))
+  }
+}
+
+
+/** Returns the first counter block for the nonce. */
+func (f CounterFormat) initial_counter(
+    nonce *blocks.Blocks, block_size int) ([]byte, error) {
+  if err := f.validate(); err != nil {
+    return nil, err
+  }
+  if block_size < 8 {
+    return nil, fmt.Errorf(
+        "%w CTR needs at least 64-bit blocks, got %d bytes.",
+        ErrBlockSize, block_size)
+  }
+  if nonce.Len() != f.nonce_size(block_size) {
+    return nil, fmt.Errorf(
+        "%w Got %d bytes, need %d.",
+        ErrInvalidNonceSize, nonce.Len(), f.nonce_size(block_size))
+  }
+  counter := make([]byte, block_size)
+  copy(counter, nonce.ToBytes())
+  return counter, nil
+}
+
+
+/** Advances the counter block in place to the next block's counter. */
+func (f CounterFormat) increment(counter []byte) {
+  switch f {
+  case CounterLittleEndian64:
+    for i := len(counter) - 8; i < len(counter); i++ {
+      counter[i]++
+      if counter[i] != 0 {
+        return
+      }
+    }
+  case CounterBigEndian128:
+    for i := len(counter) - 1; i >= 0; i-- {
+      counter[i]++
+      if counter[i] != 0 {
+        return
+      }
+    }
+  default:
+    panic(fmt.Sprintf("Unknown counter format %d.", f))
+  }
+}
+
+
+/** Advances the counter block in place by n blocks. */
+func (f CounterFormat) advance(counter []byte, n uint64) {
+  switch f {
+  case CounterLittleEndian64:
+    count := counter[len(counter) - 8:]
+    binary.LittleEndian.PutUint64(count, binary.LittleEndian.Uint64(count) + n)
+  case CounterBigEndian128:
+    carry := n
+    for i := len(counter) - 1; i >= 0 && carry != 0; i-- {
+      sum := uint64(counter[i]) + carry & 0xff
+      counter[i] = byte(sum)
+      carry = carry >> 8 + sum >> 8
+    }
+  default:
+    panic(fmt.Sprintf("Unknown counter format %d.", f))
+  }
+}
+
+
+/**
+ * En/decrypts (the operations are the same) using AES in CTR mode. The text
+ * may be any length, and is not padded.
+ */
+func CtrEncrypt(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) *blocks.Blocks {
+  return must(CtrEncryptE(text, key, nonce, format))
+}
+
+
+/**
+ * Like CtrEncrypt, but returns an error for a bad key, nonce or counter
+ * format.
+ */
+func CtrEncryptE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CtrEncryptE(text, nonce, format)
+}
+
+
+/** CTR decryption is the same as encryption. */
+func CtrDecrypt(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) *blocks.Blocks {
+  return CtrEncrypt(text, key, nonce, format)
+}
+
+
+func CtrDecryptE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) (*blocks.Blocks, error) {
+  return CtrEncryptE(text, key, nonce, format)
+}
+
+
+/** En/decrypts with this cipher in CTR mode. */
+func (m *Modes) CtrEncrypt(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) *blocks.Blocks {
+  return must(m.CtrEncryptE(text, nonce, format))
+}
+
+
+func (m *Modes) CtrEncryptE(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) (*blocks.Blocks, error) {
+  counter, err := format.initial_counter(nonce, m.BlockSize())
+  if err != nil {
+    return nil, err
+  }
+  text = m.in_blocks(text)
+  out := make([]byte, text.Len())
+  keystream := make([]byte, m.BlockSize())
+  text.EachBlock(func(i int, block []byte) {
+    m.block_cipher.Encrypt(keystream, counter)
+    out_block := out[i * m.BlockSize():i * m.BlockSize() + len(block)]
+    copy(out_block, block)
+    blocks.XorInto(out_block, keystream)
+    format.increment(counter)
+  })
+  return m.wrap_output(out), nil
+}
+
+
+func (m *Modes) CtrDecrypt(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) *blocks.Blocks {
+  return m.CtrEncrypt(text, nonce, format)
+}
+
+
+func (m *Modes) CtrDecryptE(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat) (*blocks.Blocks, error) {
+  return m.CtrEncryptE(text, nonce, format)
+}
@@ -0,0 +1,112 @@
+package aes_modes
+
+import "crypto/aes"
+import "crypto/cipher"
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestCtrCryptopals(t *testing.T) {
+  ciphertext := blocks.FromBase64(
+      "L77na/nrFsKvynd6HzOoG7GHTLXsTVu9qvY/2syLXzhPweyyMTJULu/6/kXX0KSvoOLS" +
+      "FQ==")
+  key := blocks.FromString("YELLOW SUBMARINE")
+  nonce := blocks.FromBytes(make([]byte, 8))
+  expected := "Yo, VIP Let's kick it Ice, Ice, baby Ice, Ice, baby "
+  plaintext := CtrDecrypt(ciphertext, key, nonce, CounterLittleEndian64)
+  if plaintext.ToString() != expected {
+    t.Errorf(
+        "Expected decryption as %q, but got %q.",
+        expected, plaintext.ToString())
+  }
+  round_trip := CtrEncrypt(plaintext, key, nonce, CounterLittleEndian64)
+  if !blocks.Equal(round_trip, ciphertext) {
+    t.Errorf(
+        "Expected encryption as %q, but got %q.",
+        ciphertext.ToBase64(), round_trip.ToBase64())
+  }
+}
+
+
+func TestCtrNist(t *testing.T) {
+  // NIST SP 800-38A F.5.1, CTR-AES128.Encrypt
+  key := blocks.FromHex("2b7e151628aed2a6abf7158809cf4f3c")
+  counter := blocks.FromHex("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+  plaintext := blocks.FromHex(
+      "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+      "30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+  expected :=
+      "874d6191b620e3261bef6864990db6ce9806f66b7970fdff8617187bb9fffdff" +
+      "5ae4df3edbd5d35e5b4f09020db03eab1e031dda2fbe03d1792170a0f3009cee"
+  ciphertext := CtrEncrypt(plaintext, key, counter, CounterBigEndian128)
+  if ciphertext.ToHex() != expected {
+    t.Errorf(
+        "Expected encryption as %s, but got %s.", expected, ciphertext.ToHex())
+  }
+}
+
+
+func TestCtrBigEndianCarry(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  counter := blocks.FromHex("00000000000000fffffffffffffffffe")
+  plaintext := blocks.RepeatByte('x', 70)
+  aes_cipher, _ := aes.NewCipher(key.ToBytes())
+  expected := make([]byte, plaintext.Len())
+  cipher.NewCTR(aes_cipher, counter.ToBytes()).XORKeyStream(
+      expected, plaintext.ToBytes())
+  ciphertext := CtrEncrypt(plaintext, key, counter, CounterBigEndian128)
+  if ciphertext.ToHex() != blocks.FromBytes(expected).ToHex() {
+    t.Errorf(
+        "Expected encryption as %x, but got %s.", expected, ciphertext.ToHex())
+  }
+}
+
+
+func TestCtrInvalidNonceSize(t *testing.T) {
+  _, err := CtrEncryptE(
+      blocks.FromString("pumpkin"),
+      blocks.FromString("YELLOW SUBMARINE"),
+      blocks.FromBytes(make([]byte, 16)),
+      CounterLittleEndian64)
+  if !errors.Is(err, ErrInvalidNonceSize) {
+    t.Errorf("Expected ErrInvalidNonceSize but got %v.", err)
+  }
+}
+
+
+func TestCtrInvalidCounterFormat(t *testing.T) {
+  text := blocks.FromString("pumpkin")
+  key := blocks.FromString("YELLOW SUBMARINE")
+  nonce := blocks.FromBytes(make([]byte, 8))
+  format := CounterFormat(7)
+  modes := get_modes(key)
+  for name, crypt := range map[string]func() (*blocks.Blocks, error){
+    "CtrEncryptE": func() (*blocks.Blocks, error) {
+      return CtrEncryptE(text, key, nonce, format)
+    },
+    "CtrDecryptE": func() (*blocks.Blocks, error) {
+      return CtrDecryptE(text, key, nonce, format)
+    },
+    "Modes.CtrEncryptE": func() (*blocks.Blocks, error) {
+      return modes.CtrEncryptE(text, nonce, format)
+    },
+    "Modes.CtrDecryptE": func() (*blocks.Blocks, error) {
+      return modes.CtrDecryptE(text, nonce, format)
+    },
+    "CtrEncryptParallelE": func() (*blocks.Blocks, error) {
+      return CtrEncryptParallelE(text, key, nonce, format, 2)
+    },
+    "CtrDecryptParallelE": func() (*blocks.Blocks, error) {
+      return CtrDecryptParallelE(text, key, nonce, format, 2)
+    },
+    "Modes.CtrEncryptParallelE": func() (*blocks.Blocks, error) {
+      return modes.CtrEncryptParallelE(text, nonce, format, 2)
+    },
+  } {
+    if _, err := crypt(); !errors.Is(err, ErrCounterFormat) {
+      t.Errorf("%s: expected ErrCounterFormat but got %v.", name, err)
+    }
+  }
+}
@@ -0,0 +1,219 @@
+/**
+ * CBC with ciphertext stealing, which keeps the ciphertext the same length as
+ * the plaintext (of at least one block) without padding. The three variants
+ * from the NIST SP 800-38A addendum differ only in the order of the last two
+ * ciphertext blocks.
+ * https://csrc.nist.gov/publications/detail/sp/800-38a/addendum/final
+ */
+
+package aes_modes
+
+import "errors"
+import "fmt"
+
+import "../blocks"
+
+
+type CtsVariant int
+
+const (
+  // The truncated penultimate block stays in place: C1 ... C*(n-1) Cn.
+  CbcCs1 CtsVariant = iota + 1
+  // As CS1 if the plaintext is whole blocks, otherwise as CS3.
+  CbcCs2
+  // The last two blocks are always swapped: C1 ... Cn C*(n-1). This is the
+  // variant used by Kerberos (RFC 3962).
+  CbcCs3
+)
+
+
+var ErrCtsVariant = errors.New("Unknown ciphertext stealing variant.")
+
+
+func (v CtsVariant) validate() error {
+  if v != CbcCs1 && v != CbcCs2 && v != CbcCs3 {
+    return fmt.Errorf("%w Got %d.", ErrCtsVariant, int(v))
+  }
+  return nil
+}
+
+
+/** Whether the last (full) and penultimate (partial) blocks are swapped. */
+func (v CtsVariant) swapped(partial_size int, block_size int) bool {
+  switch v {
+  case CbcCs1:
+    return false
+  case CbcCs2:
+    return partial_size != block_size
+  case CbcCs3:
+    return true
+  default:
+    panic(fmt.Sprintf("Unknown ciphertext stealing variant %d.", v))
+  }
+}
+
+
+/** Encrypts using AES in CBC mode with ciphertext stealing. */
+func CbcCtsEncrypt(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) *blocks.Blocks {
+  return must(CbcCtsEncryptE(plaintext, key, iv, variant))
+}
+
+
+/**
+ * Like CbcCtsEncrypt, but returns an error for a bad key, IV or variant, or
+ * for plaintext shorter than one block.
+ */
+func CbcCtsEncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CbcCtsEncryptE(plaintext, iv, variant)
+}
+
+
+/** Decrypts AES in CBC mode with ciphertext stealing. */
+func CbcCtsDecrypt(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) *blocks.Blocks {
+  return must(CbcCtsDecryptE(ciphertext, key, iv, variant))
+}
+
+
+func CbcCtsDecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CbcCtsDecryptE(ciphertext, iv, variant)
+}
+
+
+/**
+ * Returns the size of the final (possibly partial) block, or an error if the
+ * text is shorter than one block.
+ */
+func (m *Modes) cts_partial_size(text *blocks.Blocks) (int, error) {
+  if text.Len() < m.BlockSize() {
+    return 0, fmt.Errorf(
+        "%w Ciphertext stealing needs at least %d bytes, got %d.",
+        ErrPartialBlock, m.BlockSize(), text.Len())
+  }
+  partial_size := text.Len() % m.BlockSize()
+  if partial_size == 0 {
+    partial_size = m.BlockSize()
+  }
+  return partial_size, nil
+}
+
+
+func (m *Modes) CbcCtsEncrypt(
+    plaintext *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) *blocks.Blocks {
+  return must(m.CbcCtsEncryptE(plaintext, iv, variant))
+}
+
+
+func (m *Modes) CbcCtsEncryptE(
+    plaintext *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) (*blocks.Blocks, error) {
+  if err := variant.validate(); err != nil {
+    return nil, err
+  }
+  partial_size, err := m.cts_partial_size(plaintext)
+  if err != nil {
+    return nil, err
+  }
+  // Zero-pad the final block and encrypt as usual, then drop the bytes of the
+  // penultimate ciphertext block which decrypting the last block recovers.
+  padded := plaintext.Copy()
+  padded.AppendBytes(make([]byte, m.BlockSize() - partial_size))
+  ciphertext, err := m.cbc_encrypt(padded, iv)
+  if err != nil {
+    return nil, err
+  }
+  num_blocks := ciphertext.NumBlocks()
+  if num_blocks == 1 {
+    return ciphertext, nil
+  }
+  stolen := ciphertext.Block(num_blocks - 2).ToBytes()[:partial_size]
+  last := ciphertext.Block(num_blocks - 1)
+  out := m.new_blocks()
+  out.AppendBytes(ciphertext.ToBytes()[:(num_blocks - 2) * m.BlockSize()])
+  if variant.swapped(partial_size, m.BlockSize()) {
+    out.Append(last)
+    out.AppendBytes(stolen)
+  } else {
+    out.AppendBytes(stolen)
+    out.Append(last)
+  }
+  return out, nil
+}
+
+
+func (m *Modes) CbcCtsDecrypt(
+    ciphertext *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) *blocks.Blocks {
+  return must(m.CbcCtsDecryptE(ciphertext, iv, variant))
+}
+
+
+func (m *Modes) CbcCtsDecryptE(
+    ciphertext *blocks.Blocks,
+    iv *blocks.Blocks,
+    variant CtsVariant) (*blocks.Blocks, error) {
+  if err := variant.validate(); err != nil {
+    return nil, err
+  }
+  partial_size, err := m.cts_partial_size(ciphertext)
+  if err != nil {
+    return nil, err
+  }
+  if ciphertext.Len() == m.BlockSize() {
+    return m.cbc_decrypt(ciphertext, iv)
+  }
+  data := ciphertext.ToBytes()
+  head_size := len(data) - m.BlockSize() - partial_size
+  var stolen, last []byte
+  if variant.swapped(partial_size, m.BlockSize()) {
+    last = data[head_size:head_size + m.BlockSize()]
+    stolen = data[head_size + m.BlockSize():]
+  } else {
+    stolen = data[head_size:head_size + partial_size]
+    last = data[head_size + partial_size:]
+  }
+  // Decrypting the last block gives the zero-padded final plaintext XORed
+  // with the penultimate ciphertext block, whose missing tail that reveals.
+  xored := make([]byte, m.BlockSize())
+  m.block_cipher.Decrypt(xored, last)
+  penultimate := append(append([]byte(nil), stolen...), xored[partial_size:]...)
+  final := blocks.FromBytes(xored[:partial_size]).Xor(
+      blocks.FromBytes(penultimate))
+
+  reordered := m.new_blocks()
+  reordered.AppendBytes(data[:head_size])
+  reordered.AppendBytes(penultimate)
+  plaintext, err := m.cbc_decrypt(reordered, iv)
+  if err != nil {
+    return nil, err
+  }
+  plaintext.Append(final)
+  return plaintext, nil
+}
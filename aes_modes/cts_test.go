@@ -0,0 +1,108 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+// RFC 3962 Appendix B: AES-128 with ciphertext stealing (CBC-CS3).
+var rfc3962_key = blocks.FromHex("636869636b656e207465726979616b69")
+var rfc3962_plaintext = "I would like the General Gau's Chicken, please, " +
+    "and wonton soup."
+
+
+func TestCbcCs3Rfc3962(t *testing.T) {
+  iv := blocks.FromBytes(make([]byte, 16))
+  for _, vector := range []struct{
+      length int
+      ciphertext string
+  }{
+      {17, "c6353568f2bf8cb4d8a580362da7ff7f97"},
+      {31, "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+      {32, "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+      {47, "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e" +
+           "39312523a78662d5be7fcbcc98ebf5"},
+      {48, "97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd8" +
+           "39312523a78662d5be7fcbcc98ebf5a8"},
+      {64, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a8" +
+           "4807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+  } {
+    plaintext := blocks.FromString(rfc3962_plaintext[:vector.length])
+    ciphertext := CbcCtsEncrypt(plaintext, rfc3962_key, iv, CbcCs3)
+    if ciphertext.ToHex() != vector.ciphertext {
+      t.Errorf(
+          "Length %d: expected %s but got %s.",
+          vector.length, vector.ciphertext, ciphertext.ToHex())
+    }
+    decrypted := CbcCtsDecrypt(ciphertext, rfc3962_key, iv, CbcCs3)
+    if !blocks.Equal(decrypted, plaintext) {
+      t.Errorf(
+          "Length %d: expected decryption as %q but got %q.",
+          vector.length, plaintext.ToString(), decrypted.ToString())
+    }
+  }
+}
+
+
+func TestCbcCtsVariants(t *testing.T) {
+  iv := nist_iv
+  for length := 16; length <= 64; length++ {
+    plaintext := blocks.FromString(rfc3962_plaintext[:length])
+    cs1 := CbcCtsEncrypt(plaintext, rfc3962_key, iv, CbcCs1)
+    cs2 := CbcCtsEncrypt(plaintext, rfc3962_key, iv, CbcCs2)
+    cs3 := CbcCtsEncrypt(plaintext, rfc3962_key, iv, CbcCs3)
+    if cs1.Len() != length || cs2.Len() != length || cs3.Len() != length {
+      t.Errorf(
+          "Length %d: ciphertext lengths %d, %d, %d.",
+          length, cs1.Len(), cs2.Len(), cs3.Len())
+    }
+    if length % 16 == 0 {
+      // Aligned, CS1 and CS2 are plain CBC.
+      cbc := CbcEncryptUnpadded(plaintext, rfc3962_key, iv)
+      if !blocks.Equal(cs1, cbc) || !blocks.Equal(cs2, cbc) {
+        t.Errorf("Length %d: CS1/CS2 should match CBC.", length)
+      }
+    } else if !blocks.Equal(cs2, cs3) {
+      t.Errorf("Length %d: CS2 should match CS3.", length)
+    }
+    for variant, ciphertext := range map[CtsVariant]*blocks.Blocks{
+        CbcCs1: cs1, CbcCs2: cs2, CbcCs3: cs3} {
+      decrypted := CbcCtsDecrypt(ciphertext, rfc3962_key, iv, variant)
+      if !blocks.Equal(decrypted, plaintext) {
+        t.Errorf(
+            "Length %d, CS%d: decrypted as %q.",
+            length, variant, decrypted.ToString())
+      }
+    }
+  }
+}
+
+
+func TestCbcCtsTooShort(t *testing.T) {
+  _, err := CbcCtsEncryptE(
+      blocks.FromString("fifteen bytes!!"), rfc3962_key, nist_iv, CbcCs3)
+  if !errors.Is(err, ErrPartialBlock) {
+    t.Errorf("Expected ErrPartialBlock but got %v.", err)
+  }
+}
+
+
+func TestCbcCtsInvalidVariant(t *testing.T) {
+  // Even a single block, which no variant reorders, checks the variant.
+  for _, plaintext := range []*blocks.Blocks{
+      blocks.RepeatByte('a', 16), blocks.RepeatByte('a', 20)} {
+    _, err := CbcCtsEncryptE(plaintext, rfc3962_key, nist_iv, CtsVariant(0))
+    if !errors.Is(err, ErrCtsVariant) {
+      t.Errorf(
+          "Encrypting %d bytes: expected ErrCtsVariant but got %v.",
+          plaintext.Len(), err)
+    }
+    _, err = CbcCtsDecryptE(plaintext, rfc3962_key, nist_iv, CtsVariant(4))
+    if !errors.Is(err, ErrCtsVariant) {
+      t.Errorf(
+          "Decrypting %d bytes: expected ErrCtsVariant but got %v.",
+          plaintext.Len(), err)
+    }
+  }
+}
@@ -0,0 +1,137 @@
+/**
+ * EAX (Bellare, Rogaway and Wagner): CTR encryption, authenticated with OMAC
+ * (CMAC) over the nonce, the header (associated data) and the ciphertext,
+ * each domain-separated by a prefix block.
+ * https://web.cs.ucdavis.edu/~rogaway/papers/eax.pdf
+ */
+
+package aes_modes
+
+import "crypto/subtle"
+import "fmt"
+
+import "../blocks"
+
+
+const EaxTagSize int = 16
+
+
+/** OMAC^t: the CMAC of a block holding t, followed by the data. */
+func (m *Modes) omac(t byte, data []byte) []byte {
+  input := make([]byte, gcm_block_size)
+  input[gcm_block_size - 1] = t
+  return m.cmac(append(input, data...))
+}
+
+
+/**
+ * Encrypts and authenticates the plaintext with AES-EAX, and authenticates the
+ * additional data. The nonce may be any length. Returns the ciphertext
+ * followed by the 16-byte tag.
+ */
+func EaxSeal(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) *blocks.Blocks {
+  return must(EaxSealE(plaintext, key, nonce, additional_data))
+}
+
+
+/** Like EaxSeal, but returns an error for a bad key. */
+func EaxSealE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.EaxSealE(plaintext, nonce, additional_data)
+}
+
+
+/**
+ * Decrypts and verifies (in constant time) output from EaxSeal. Returns
+ * ErrAuthentication, and no plaintext, if verification fails.
+ */
+func EaxOpen(
+    sealed *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.EaxOpen(sealed, nonce, additional_data)
+}
+
+
+/** EAX-encrypts with this (128-bit block) cipher. */
+func (m *Modes) EaxSeal(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) *blocks.Blocks {
+  return must(m.EaxSealE(plaintext, nonce, additional_data))
+}
+
+
+func (m *Modes) EaxSealE(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  if m.BlockSize() != gcm_block_size {
+    return nil, fmt.Errorf(
+        "%w EAX needs a %d-byte block cipher, got %d.",
+        ErrBlockSize, gcm_block_size, m.BlockSize())
+  }
+  n := m.omac(0, nonce.ToBytes())
+  ciphertext, err := m.CtrEncryptE(
+      plaintext, blocks.FromBytes(n), CounterBigEndian128)
+  if err != nil {
+    return nil, err
+  }
+  ciphertext.AppendBytes(m.eax_tag(n, additional_data, ciphertext))
+  return ciphertext, nil
+}
+
+
+func (m *Modes) EaxOpen(
+    sealed *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  if m.BlockSize() != gcm_block_size {
+    return nil, fmt.Errorf(
+        "%w EAX needs a %d-byte block cipher, got %d.",
+        ErrBlockSize, gcm_block_size, m.BlockSize())
+  }
+  if sealed.Len() < EaxTagSize {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for a tag.", ErrAuthentication, sealed.Len())
+  }
+  split := sealed.Len() - EaxTagSize
+  ciphertext := blocks.FromBytes(sealed.ToBytes()[:split])
+  n := m.omac(0, nonce.ToBytes())
+  expected := m.eax_tag(n, additional_data, ciphertext)
+  if subtle.ConstantTimeCompare(expected, sealed.ToBytes()[split:]) != 1 {
+    return nil, ErrAuthentication
+  }
+  return m.CtrEncryptE(ciphertext, blocks.FromBytes(n), CounterBigEndian128)
+}
+
+
+/** The tag is OMAC^0(nonce) ^ OMAC^1(header) ^ OMAC^2(ciphertext). */
+func (m *Modes) eax_tag(
+    n []byte,
+    additional_data *blocks.Blocks,
+    ciphertext *blocks.Blocks) []byte {
+  h := m.omac(1, additional_data.ToBytes())
+  c := m.omac(2, ciphertext.ToBytes())
+  tag := make([]byte, gcm_block_size)
+  for i := range tag {
+    tag[i] = n[i] ^ h[i] ^ c[i]
+  }
+  return tag
+}
@@ -0,0 +1,59 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestEaxPaper(t *testing.T) {
+  // The first test vectors from the EAX paper's appendix.
+  for i, vector := range []struct{
+      message, key, nonce, header, sealed string
+  }{
+      {"", "233952dee4d5ed5f9b9c6d6ff80ff478",
+       "62ec67f9c3a4a407fcb2a8c49031a8b3", "6bfb914fd07eae6b",
+       "e037830e8389f27b025a2d6527e79d01"},
+      {"f7fb", "91945d3f4dcbee0bf45ef52255f095a4",
+       "becaf043b0a23d843194ba972c66debd", "fa3bfd4806eb53fa",
+       "19dd5c4c9331049d0bdab0277408f67967e5"},
+      {"1a47cb4933", "01f74ad64077f2e704c0f60ada3dd523",
+       "70c3db4f0d26368400a10ed05d2bff5e", "234a3463c1264ac6",
+       "d851d5bae03a59f238a23e39199dc9266626c40f80"},
+  } {
+    plaintext := blocks.FromHex(vector.message)
+    key := blocks.FromHex(vector.key)
+    nonce := blocks.FromHex(vector.nonce)
+    header := blocks.FromHex(vector.header)
+    sealed := EaxSeal(plaintext, key, nonce, header)
+    if sealed.ToHex() != vector.sealed {
+      t.Errorf(
+          "Vector %d: expected %s but got %s.",
+          i, vector.sealed, sealed.ToHex())
+    }
+    opened, err := EaxOpen(sealed, key, nonce, header)
+    if err != nil || !blocks.Equal(opened, plaintext) {
+      t.Errorf("Vector %d did not round-trip: %v.", i, err)
+    }
+  }
+}
+
+
+func TestEaxRejectsTampering(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 16)
+  nonce := blocks.RepeatByte(0x24, 16)
+  ad := blocks.FromString("header")
+  sealed := EaxSeal(blocks.FromString("Ice, ice, baby."), key, nonce, ad)
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 1
+    _, err := EaxOpen(blocks.FromBytes(tampered), key, nonce, ad)
+    if !errors.Is(err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  _, err := EaxOpen(sealed, key, blocks.RepeatByte(0x25, 16), ad)
+  if !errors.Is(err, ErrAuthentication) {
+    t.Errorf("Changed nonce gave %v.", err)
+  }
+}
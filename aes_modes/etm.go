@@ -0,0 +1,120 @@
+/**
+ * Encrypt-then-MAC: AES-CBC with a random IV, authenticated by HMAC-SHA256
+ * over IV || ciphertext. Unlike plain CbcDecrypt, OpenCbcHmac checks the tag
+ * before decrypting, so tampered input never reaches the padding check (and
+ * there is no padding oracle).
+ */
+
+package aes_modes
+
+import "crypto/aes"
+import "crypto/hmac"
+import "crypto/sha256"
+import "fmt"
+
+import "../blocks"
+
+
+const CbcHmacTagSize int = sha256.Size
+
+
+/**
+ * Derives separate encryption and MAC keys from the master key, as
+ * HMAC-SHA256(key, label). The encryption key is the master key's length.
+ */
+func cbc_hmac_keys(key *blocks.Blocks) (*blocks.Blocks, []byte, error) {
+  if key.Len() != 16 && key.Len() != 24 && key.Len() != 32 {
+    return nil, nil, fmt.Errorf(
+        "%w Got %d bytes, need 16, 24 or 32.", ErrInvalidKeySize, key.Len())
+  }
+  derive := func(label string) []byte {
+    h := hmac.New(sha256.New, key.ToBytes())
+    h.Write([]byte(label))
+    return h.Sum(nil)
+  }
+  encryption_key := derive("CBC-HMAC-SHA256 encryption")[:key.Len()]
+  return blocks.FromBytes(encryption_key), derive("CBC-HMAC-SHA256 MAC"), nil
+}
+
+
+func cbc_hmac_tag(mac_key []byte, iv_and_ciphertext []byte) []byte {
+  h := hmac.New(sha256.New, mac_key)
+  h.Write(iv_and_ciphertext)
+  return h.Sum(nil)
+}
+
+
+/**
+ * Encrypts and authenticates with a 16, 24 or 32 byte key, returning
+ * IV || ciphertext || tag.
+ */
+func SealCbcHmac(plaintext *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+  return must(SealCbcHmacE(plaintext, key))
+}
+
+
+/** Like SealCbcHmac, but returns an error for a bad key. */
+func SealCbcHmacE(
+    plaintext *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  return SealCbcHmacFrom(blocks.CryptoRand, plaintext, key)
+}
+
+
+/**
+ * Like SealCbcHmacE, but takes the IV from r. Only for reproducible tests and
+ * demos: a predictable IV is insecure.
+ */
+func SealCbcHmacFrom(
+    r blocks.Rand,
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks) (*blocks.Blocks, error) {
+  return seal_cbc_hmac(plaintext, key, blocks.RandomBlockFrom(r, aes.BlockSize))
+}
+
+
+func seal_cbc_hmac(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  encryption_key, mac_key, err := cbc_hmac_keys(key)
+  if err != nil {
+    return nil, err
+  }
+  ciphertext, err := CbcEncryptE(plaintext, encryption_key, iv)
+  if err != nil {
+    return nil, err
+  }
+  sealed := iv.Copy()
+  sealed.Append(ciphertext)
+  sealed.AppendBytes(cbc_hmac_tag(mac_key, sealed.ToBytes()))
+  return sealed, nil
+}
+
+
+/**
+ * Verifies (in constant time) and decrypts IV || ciphertext || tag from
+ * SealCbcHmac. Returns ErrAuthentication, without decrypting, if the tag does
+ * not match.
+ */
+func OpenCbcHmac(
+    sealed *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  encryption_key, mac_key, err := cbc_hmac_keys(key)
+  if err != nil {
+    return nil, err
+  }
+  // IV, at least one (padding) block, and the tag.
+  if sealed.Len() < 2 * aes.BlockSize + CbcHmacTagSize {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for IV, ciphertext and tag.",
+        ErrAuthentication, sealed.Len())
+  }
+  split := sealed.Len() - CbcHmacTagSize
+  sealed_bytes := sealed.ToBytes()
+  expected_tag := cbc_hmac_tag(mac_key, sealed_bytes[:split])
+  if !hmac.Equal(expected_tag, sealed_bytes[split:]) {
+    return nil, ErrAuthentication
+  }
+  iv := blocks.FromBytes(sealed_bytes[:aes.BlockSize])
+  ciphertext := blocks.FromBytes(sealed_bytes[aes.BlockSize:split])
+  return CbcDecryptE(ciphertext, encryption_key, iv)
+}
@@ -0,0 +1,99 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestCbcHmacRoundTrip(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  for length := 0; length <= 33; length++ {
+    plaintext := blocks.RepeatByte('p', length)
+    sealed := SealCbcHmac(plaintext, key)
+    padded_length := (length / 16 + 1) * 16
+    if sealed.Len() != 16 + padded_length + CbcHmacTagSize {
+      t.Errorf("Length %d sealed to %d bytes.", length, sealed.Len())
+    }
+    opened, err := OpenCbcHmac(sealed, key)
+    if err != nil || !blocks.Equal(opened, plaintext) {
+      t.Errorf("Length %d did not round-trip: %v.", length, err)
+    }
+  }
+}
+
+
+func TestCbcHmacRandomIv(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  plaintext := blocks.FromString("Ice, ice, baby.")
+  if blocks.Equal(SealCbcHmac(plaintext, key), SealCbcHmac(plaintext, key)) {
+    t.Errorf("Sealing twice gave the same output.")
+  }
+}
+
+
+func TestCbcHmacFromSeed(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  plaintext := blocks.FromString("Ice, ice, baby.")
+  first, err := SealCbcHmacFrom(blocks.NewSeededRand(5), plaintext, key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  second, _ := SealCbcHmacFrom(blocks.NewSeededRand(5), plaintext, key)
+  if !blocks.Equal(first, second) {
+    t.Errorf("The same seed gave %s and %s.", first.ToHex(), second.ToHex())
+  }
+  opened, err := OpenCbcHmac(first, key)
+  if err != nil || !blocks.Equal(opened, plaintext) {
+    t.Errorf("Did not round-trip: %v.", err)
+  }
+}
+
+
+func TestCbcHmacSeparateKeys(t *testing.T) {
+  // The ciphertext is not under the master key itself.
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.RepeatByte(0, 16)
+  plaintext := blocks.FromString("Ice, ice, baby.")
+  sealed, err := seal_cbc_hmac(plaintext, key, iv)
+  if err != nil {
+    t.Fatal(err)
+  }
+  direct := CbcEncrypt(plaintext, key, iv)
+  if blocks.Equal(blocks.FromBytes(sealed.ToBytes()[16:32]), direct) {
+    t.Errorf("Encrypted with the master key.")
+  }
+}
+
+
+func TestCbcHmacRejectsTampering(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  sealed := SealCbcHmac(blocks.FromString("Ice, ice, baby."), key)
+  // Every byte (IV, ciphertext and tag) is covered.
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 1
+    _, err := OpenCbcHmac(blocks.FromBytes(tampered), key)
+    if !errors.Is(err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  truncated := blocks.FromBytes(sealed.ToBytes()[:sealed.Len() - 16])
+  if _, err := OpenCbcHmac(truncated, key); !errors.Is(
+      err, ErrAuthentication) {
+    t.Errorf("Truncated input gave %v.", err)
+  }
+  other_key := blocks.FromString("ORANGE SUBMARINE")
+  if _, err := OpenCbcHmac(sealed, other_key); !errors.Is(
+      err, ErrAuthentication) {
+    t.Errorf("Wrong key gave %v.", err)
+  }
+}
+
+
+func TestCbcHmacInvalidKeySize(t *testing.T) {
+  _, err := SealCbcHmacE(blocks.FromString("x"), blocks.FromString("short"))
+  if !errors.Is(err, ErrInvalidKeySize) {
+    t.Errorf("Expected ErrInvalidKeySize but got %v.", err)
+  }
+}
@@ -0,0 +1,289 @@
+/**
+ * Output feedback (OFB) and cipher feedback (CFB, CFB-8) modes. None of these
+ * pad; the text may be any length.
+ * https://en.wikipedia.org/wiki/Block_cipher_mode_of_operation
+ */
+
+package aes_modes
+
+import "../blocks"
+
+
+/**
+ * En/decrypts (the operations are the same) using AES in OFB mode. The
+ * keystream depends only on the key and IV, so reusing them reuses the
+ * keystream.
+ */
+func OfbEncrypt(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(OfbEncryptE(text, key, iv))
+}
+
+
+/** Like OfbEncrypt, but returns an error for a bad key or IV. */
+func OfbEncryptE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.OfbEncryptE(text, iv)
+}
+
+
+/** OFB decryption is the same as encryption. */
+func OfbDecrypt(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return OfbEncrypt(text, key, iv)
+}
+
+
+func OfbDecryptE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return OfbEncryptE(text, key, iv)
+}
+
+
+/**
+ * Encrypts using AES in full-block CFB mode (CFB-128). A partial final block
+ * uses only as much of the keystream as it needs.
+ */
+func CfbEncrypt(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(CfbEncryptE(plaintext, key, iv))
+}
+
+
+/** Like CfbEncrypt, but returns an error for a bad key or IV. */
+func CfbEncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CfbEncryptE(plaintext, iv)
+}
+
+
+/**
+ * Decrypts full-block CFB mode. A bit flipped in one ciphertext block flips
+ * the same bit in that plaintext block and garbles the next block.
+ */
+func CfbDecrypt(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(CfbDecryptE(ciphertext, key, iv))
+}
+
+
+func CfbDecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CfbDecryptE(ciphertext, iv)
+}
+
+
+/**
+ * Encrypts using AES in CFB-8 mode: one AES operation per byte, shifting each
+ * ciphertext byte into the feedback register.
+ */
+func Cfb8Encrypt(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(Cfb8EncryptE(plaintext, key, iv))
+}
+
+
+/** Like Cfb8Encrypt, but returns an error for a bad key or IV. */
+func Cfb8EncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.Cfb8EncryptE(plaintext, iv)
+}
+
+
+/**
+ * Decrypts CFB-8 mode. An error in one ciphertext byte garbles the following
+ * block's worth of bytes, until it is shifted out of the feedback register.
+ */
+func Cfb8Decrypt(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(Cfb8DecryptE(ciphertext, key, iv))
+}
+
+
+func Cfb8DecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.Cfb8DecryptE(ciphertext, iv)
+}
+
+
+/** En/decrypts with this cipher in OFB mode. */
+func (m *Modes) OfbEncrypt(
+    text *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.OfbEncryptE(text, iv))
+}
+
+
+func (m *Modes) OfbEncryptE(
+    text *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  text = m.in_blocks(text)
+  out := make([]byte, text.Len())
+  keystream := iv.Copy().ToBytes()
+  text.EachBlock(func(i int, block []byte) {
+    m.block_cipher.Encrypt(keystream, keystream)
+    out_block := out[i * m.BlockSize():i * m.BlockSize() + len(block)]
+    copy(out_block, block)
+    blocks.XorInto(out_block, keystream)
+  })
+  return m.wrap_output(out), nil
+}
+
+
+func (m *Modes) OfbDecrypt(
+    text *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return m.OfbEncrypt(text, iv)
+}
+
+
+func (m *Modes) OfbDecryptE(
+    text *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.OfbEncryptE(text, iv)
+}
+
+
+/** Encrypts with this cipher in full-block CFB mode. */
+func (m *Modes) CfbEncrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.CfbEncryptE(plaintext, iv))
+}
+
+
+func (m *Modes) CfbEncryptE(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.cfb_crypt(plaintext, iv, false)
+}
+
+
+func (m *Modes) CfbDecrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.CfbDecryptE(ciphertext, iv))
+}
+
+
+func (m *Modes) CfbDecryptE(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.cfb_crypt(ciphertext, iv, true)
+}
+
+
+func (m *Modes) cfb_crypt(
+    text *blocks.Blocks,
+    iv *blocks.Blocks,
+    decrypt bool) (*blocks.Blocks, error) {
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  text = m.in_blocks(text)
+  out := make([]byte, text.Len())
+  prev_cipher_block := iv.ToBytes()
+  keystream := make([]byte, m.BlockSize())
+  text.EachBlock(func(i int, in_block []byte) {
+    m.block_cipher.Encrypt(keystream, prev_cipher_block)
+    out_block := out[i * m.BlockSize():i * m.BlockSize() + len(in_block)]
+    copy(out_block, in_block)
+    blocks.XorInto(out_block, keystream)
+    if decrypt {
+      prev_cipher_block = in_block
+    } else {
+      prev_cipher_block = out_block
+    }
+  })
+  return m.wrap_output(out), nil
+}
+
+
+/** Encrypts with this cipher in CFB-8 mode. */
+func (m *Modes) Cfb8Encrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.Cfb8EncryptE(plaintext, iv))
+}
+
+
+func (m *Modes) Cfb8EncryptE(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.cfb8_crypt(plaintext, iv, false)
+}
+
+
+func (m *Modes) Cfb8Decrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.Cfb8DecryptE(ciphertext, iv))
+}
+
+
+func (m *Modes) Cfb8DecryptE(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.cfb8_crypt(ciphertext, iv, true)
+}
+
+
+func (m *Modes) cfb8_crypt(
+    text *blocks.Blocks,
+    iv *blocks.Blocks,
+    decrypt bool) (*blocks.Blocks, error) {
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  in_bytes := text.ToBytes()
+  out := make([]byte, len(in_bytes))
+  register := iv.Copy().ToBytes()
+  keystream := make([]byte, m.BlockSize())
+  for i, in_byte := range in_bytes {
+    m.block_cipher.Encrypt(keystream, register)
+    out_byte := in_byte ^ keystream[0]
+    out[i] = out_byte
+    cipher_byte := out_byte
+    if decrypt {
+      cipher_byte = in_byte
+    }
+    copy(register, register[1:])
+    register[len(register) - 1] = cipher_byte
+  }
+  return m.wrap_output(out), nil
+}
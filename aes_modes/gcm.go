@@ -0,0 +1,292 @@
+/**
+ * AES in Galois/Counter Mode (GCM), an authenticated encryption mode. GHASH
+ * and GF(2^128) multiplication are exported so attacks on GCM (such as
+ * recovering the authentication key after nonce reuse) can be built on them.
+ * https://cryptopals.com/sets/8/challenges/63
+ * https://csrc.nist.gov/publications/detail/sp/800-38d/final
+ */
+
+package aes_modes
+
+import "crypto/subtle"
+import "encoding/binary"
+import "errors"
+import "fmt"
+
+import "../blocks"
+
+
+const GcmTagSize int = 16
+const gcm_block_size int = 16
+
+
+var ErrAuthentication = errors.New("Message authentication failed.")
+
+
+/**
+ * An element of GF(2^128) in GCM's bit order: the first bit of the block is
+ * the coefficient of x^0. hi holds the first 8 bytes, big-endian.
+ */
+type gf_element struct {
+  hi, lo uint64
+}
+
+
+func gf_from_bytes(block []byte) gf_element {
+  return gf_element{
+      hi: binary.BigEndian.Uint64(block[:8]),
+      lo: binary.BigEndian.Uint64(block[8:])}
+}
+
+
+func (x gf_element) to_bytes() []byte {
+  out := make([]byte, gcm_block_size)
+  binary.BigEndian.PutUint64(out[:8], x.hi)
+  binary.BigEndian.PutUint64(out[8:], x.lo)
+  return out
+}
+
+
+/** Multiplies modulo x^128 + x^7 + x^2 + x + 1 (SP 800-38D algorithm 1). */
+func (x gf_element) mul(y gf_element) gf_element {
+  var z gf_element
+  v := y
+  for i := 0; i < 128; i++ {
+    word := x.hi
+    if i >= 64 {
+      word = x.lo
+    }
+    if word & (uint64(1) << uint(63 - i % 64)) != 0 {
+      z.hi ^= v.hi
+      z.lo ^= v.lo
+    }
+    reduce := v.lo & 1 != 0
+    v.lo = v.lo >> 1 | v.hi << 63
+    v.hi >>= 1
+    if reduce {
+      v.hi ^= 0xe1 << 56
+    }
+  }
+  return z
+}
+
+
+func check_gf_size(value *blocks.Blocks) {
+  if value.Len() != gcm_block_size {
+    panic(fmt.Sprintf(
+        "GF(2^128) elements are %d bytes, got %d.",
+        gcm_block_size, value.Len()))
+  }
+}
+
+
+/** Multiplies two 16-byte blocks as elements of GCM's GF(2^128). */
+func GfMultiply(x *blocks.Blocks, y *blocks.Blocks) *blocks.Blocks {
+  check_gf_size(x)
+  check_gf_size(y)
+  return blocks.FromBytes(
+      gf_from_bytes(x.ToBytes()).mul(gf_from_bytes(y.ToBytes())).to_bytes())
+}
+
+
+/** Returns GCM's authentication key H for AES with the key. */
+func GcmAuthKey(key *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).GcmAuthKey()
+}
+
+
+/** Returns the authentication key H, the encryption of a zero block. */
+func (m *Modes) GcmAuthKey() *blocks.Blocks {
+  if m.BlockSize() != gcm_block_size {
+    panic(fmt.Sprintf(
+        "GCM needs a %d-byte block cipher, got %d.",
+        gcm_block_size, m.BlockSize()))
+  }
+  h := make([]byte, gcm_block_size)
+  m.block_cipher.Encrypt(h, h)
+  return blocks.FromBytes(h)
+}
+
+
+/**
+ * Computes GHASH with authentication key h over the additional data and
+ * ciphertext, each zero-padded to whole blocks, followed by their bit lengths.
+ */
+func Ghash(
+    h *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    ciphertext *blocks.Blocks) *blocks.Blocks {
+  check_gf_size(h)
+  h_element := gf_from_bytes(h.ToBytes())
+  var y gf_element
+  absorb := func(data []byte) {
+    for start := 0; start < len(data); start += gcm_block_size {
+      block := make([]byte, gcm_block_size)
+      copy(block, data[start:])
+      x := gf_from_bytes(block)
+      y = gf_element{hi: y.hi ^ x.hi, lo: y.lo ^ x.lo}.mul(h_element)
+    }
+  }
+  absorb(additional_data.ToBytes())
+  absorb(ciphertext.ToBytes())
+  lengths := make([]byte, gcm_block_size)
+  binary.BigEndian.PutUint64(lengths[:8], uint64(additional_data.Len()) * 8)
+  binary.BigEndian.PutUint64(lengths[8:], uint64(ciphertext.Len()) * 8)
+  absorb(lengths)
+  return blocks.FromBytes(y.to_bytes())
+}
+
+
+/**
+ * Returns the pre-counter block J0: for the usual 96-bit nonce, the nonce and
+ * a counter of 1; otherwise the GHASH of the nonce.
+ */
+func gcm_initial_counter(h *blocks.Blocks, nonce *blocks.Blocks) []byte {
+  if nonce.Len() == 12 {
+    j0 := make([]byte, gcm_block_size)
+    copy(j0, nonce.ToBytes())
+    j0[gcm_block_size - 1] = 1
+    return j0
+  }
+  return Ghash(h, blocks.New(), nonce).ToBytes()
+}
+
+
+/** Increments the last 32 bits of the counter block, big-endian. */
+func gcm_increment(counter []byte) {
+  low := counter[gcm_block_size - 4:]
+  binary.BigEndian.PutUint32(low, binary.BigEndian.Uint32(low) + 1)
+}
+
+
+/**
+ * Encrypts and authenticates the plaintext with AES-GCM, and authenticates the
+ * additional data. Returns the ciphertext followed by the 16-byte tag.
+ */
+func GcmSeal(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) *blocks.Blocks {
+  return must(GcmSealE(plaintext, key, nonce, additional_data))
+}
+
+
+/** Like GcmSeal, but returns an error for a bad key or nonce. */
+func GcmSealE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.GcmSealE(plaintext, nonce, additional_data)
+}
+
+
+/**
+ * Verifies (in constant time) and decrypts ciphertext and tag from GcmSeal.
+ * Returns ErrAuthentication, and no plaintext, if verification fails.
+ */
+func GcmOpen(
+    sealed *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.GcmOpen(sealed, nonce, additional_data)
+}
+
+
+/** GCM-encrypts with this (128-bit block) cipher. */
+func (m *Modes) GcmSeal(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) *blocks.Blocks {
+  return must(m.GcmSealE(plaintext, nonce, additional_data))
+}
+
+
+func (m *Modes) GcmSealE(
+    plaintext *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext, tag, err := m.gcm_crypt(
+      plaintext, nonce, additional_data, false)
+  if err != nil {
+    return nil, err
+  }
+  ciphertext.AppendBytes(tag)
+  return ciphertext, nil
+}
+
+
+func (m *Modes) GcmOpen(
+    sealed *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  if sealed.Len() < GcmTagSize {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for a tag.", ErrAuthentication, sealed.Len())
+  }
+  split := sealed.Len() - GcmTagSize
+  ciphertext := blocks.FromBytes(sealed.ToBytes()[:split])
+  plaintext, expected_tag, err := m.gcm_crypt(
+      ciphertext, nonce, additional_data, true)
+  if err != nil {
+    return nil, err
+  }
+  if subtle.ConstantTimeCompare(expected_tag, sealed.ToBytes()[split:]) != 1 {
+    return nil, ErrAuthentication
+  }
+  return plaintext, nil
+}
+
+
+/**
+ * Returns the ciphertext (or plaintext) and the tag. Decryption differs only
+ * in which text the tag covers.
+ */
+func (m *Modes) gcm_crypt(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks,
+    decrypt bool) (*blocks.Blocks, []byte, error) {
+  if m.BlockSize() != gcm_block_size {
+    return nil, nil, fmt.Errorf(
+        "%w GCM needs a %d-byte block cipher, got %d.",
+        ErrBlockSize, gcm_block_size, m.BlockSize())
+  }
+  if nonce.Empty() {
+    return nil, nil, fmt.Errorf("%w GCM needs a nonce.", ErrInvalidNonceSize)
+  }
+  h := m.GcmAuthKey()
+  j0 := gcm_initial_counter(h, nonce)
+  counter := append([]byte(nil), j0...)
+  text = m.in_blocks(text)
+  out_bytes := make([]byte, text.Len())
+  keystream := make([]byte, gcm_block_size)
+  text.EachBlock(func(i int, block []byte) {
+    gcm_increment(counter)
+    m.block_cipher.Encrypt(keystream, counter)
+    out_block := out_bytes[i * gcm_block_size:i * gcm_block_size + len(block)]
+    copy(out_block, block)
+    blocks.XorInto(out_block, keystream)
+  })
+  out := m.wrap_output(out_bytes)
+  ciphertext := out
+  if decrypt {
+    ciphertext = text
+  }
+  tag := Ghash(h, additional_data, ciphertext).ToBytes()
+  m.block_cipher.Encrypt(keystream, j0)
+  for i := range tag {
+    tag[i] ^= keystream[i]
+  }
+  return out, tag, nil
+}
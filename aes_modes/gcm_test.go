@@ -0,0 +1,111 @@
+package aes_modes
+
+import "crypto/aes"
+import "crypto/cipher"
+import "crypto/des"
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestGcmZeroKey(t *testing.T) {
+  // McGrew & Viega, "The Galois/Counter Mode of Operation", test cases 1-2.
+  key := blocks.FromBytes(make([]byte, 16))
+  nonce := blocks.FromBytes(make([]byte, 12))
+  expected_h := "66e94bd4ef8a2c3b884cfa59ca342b2e"
+  if GcmAuthKey(key).ToHex() != expected_h {
+    t.Errorf("Expected H %s but got %s.", expected_h, GcmAuthKey(key).ToHex())
+  }
+  for _, vector := range []struct{ plaintext, sealed string }{
+      {"", "58e2fccefa7e3061367f1d57a4e7455a"},
+      {"00000000000000000000000000000000",
+       "0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf"}} {
+    sealed := GcmSeal(
+        blocks.FromHex(vector.plaintext), key, nonce, blocks.New())
+    if sealed.ToHex() != vector.sealed {
+      t.Errorf(
+          "Expected sealed as %s but got %s.", vector.sealed, sealed.ToHex())
+    }
+  }
+}
+
+
+func TestGcmMatchesStandardLibrary(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  aes_cipher, _ := aes.NewCipher(key.ToBytes())
+  plaintext := blocks.FromString(
+      "Rollin' in my 5.0\nWith my rag-top down so my hair can blow")
+  additional_data := blocks.FromString("The girlies on standby")
+  for _, nonce_size := range [...]int{12, 8, 16, 20} {
+    gcm, _ := cipher.NewGCMWithNonceSize(aes_cipher, nonce_size)
+    nonce := blocks.RepeatByte(0x5a, nonce_size)
+    for _, length := range [...]int{0, 1, 16, 17, plaintext.Len()} {
+      text := blocks.FromBytes(plaintext.ToBytes()[:length])
+      expected := gcm.Seal(
+          nil, nonce.ToBytes(), text.ToBytes(), additional_data.ToBytes())
+      sealed := GcmSeal(text, key, nonce, additional_data)
+      if sealed.ToHex() != blocks.FromBytes(expected).ToHex() {
+        t.Errorf(
+            "Nonce size %d, length %d: expected %x but got %s.",
+            nonce_size, length, expected, sealed.ToHex())
+      }
+      opened, err := GcmOpen(sealed, key, nonce, additional_data)
+      if err != nil || !blocks.Equal(opened, text) {
+        t.Errorf(
+            "Nonce size %d, length %d: failed to open (%v).",
+            nonce_size, length, err)
+      }
+    }
+  }
+}
+
+
+func TestGcmOpenRejectsTampering(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  nonce := blocks.RepeatByte(0x01, 12)
+  additional_data := blocks.FromString("header")
+  sealed := GcmSeal(blocks.FromString("attack at dawn"), key, nonce,
+      additional_data)
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 0x80
+    _, err := GcmOpen(blocks.FromBytes(tampered), key, nonce, additional_data)
+    if !errors.Is(err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d: expected ErrAuthentication, got %v.", i, err)
+    }
+  }
+  _, err := GcmOpen(sealed, key, nonce, blocks.FromString("Header"))
+  if !errors.Is(err, ErrAuthentication) {
+    t.Errorf(
+        "Changed additional data: expected ErrAuthentication, got %v.", err)
+  }
+}
+
+
+func TestGfMultiply(t *testing.T) {
+  // In GCM's bit order, 0x80 followed by zeros is the multiplicative identity.
+  one := blocks.FromHex("80000000000000000000000000000000")
+  x := blocks.FromHex("66e94bd4ef8a2c3b884cfa59ca342b2e")
+  if !blocks.Equal(GfMultiply(x, one), x) ||
+      !blocks.Equal(GfMultiply(one, x), x) {
+    t.Errorf("Multiplying by one changed %s.", x.ToHex())
+  }
+  y := blocks.FromHex("0388dace60b6a392f328c2b971b2fe78")
+  if !blocks.Equal(GfMultiply(x, y), GfMultiply(y, x)) {
+    t.Errorf(
+        "Multiplication is not commutative for %s, %s.", x.ToHex(), y.ToHex())
+  }
+}
+
+
+func TestGcmNeeds128BitBlocks(t *testing.T) {
+  des_cipher, _ := des.NewCipher([]byte("8bytekey"))
+  _, err := WithCipher(des_cipher).GcmSealE(
+      blocks.FromString("attack at dawn"),
+      blocks.RepeatByte(0x01, 12),
+      blocks.New())
+  if !errors.Is(err, ErrBlockSize) {
+    t.Errorf("Expected ErrBlockSize for DES, got %v.", err)
+  }
+}
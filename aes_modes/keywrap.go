@@ -0,0 +1,201 @@
+/**
+ * AES key wrap (RFC 3394), and key wrap with padding (RFC 5649), for
+ * encrypting keys under a key-encryption key (KEK). The integrity check is an
+ * IV that unwrapping must recover.
+ * https://tools.ietf.org/html/rfc3394
+ * https://tools.ietf.org/html/rfc5649
+ */
+
+package aes_modes
+
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+
+import "../blocks"
+
+
+const key_wrap_semiblock int = 8
+
+
+var ErrIntegrity = errors.New("Key unwrap integrity check failed.")
+
+
+var key_wrap_iv = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+var key_wrap_padded_iv_prefix = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+
+/**
+ * Wraps key data (a multiple of 8 bytes, at least 16) under the 16, 24 or 32
+ * byte KEK.
+ */
+func KeyWrap(key_data *blocks.Blocks, kek *blocks.Blocks) *blocks.Blocks {
+  return must(KeyWrapE(key_data, kek))
+}
+
+
+/** Like KeyWrap, but returns an error for a bad KEK or key data length. */
+func KeyWrapE(
+    key_data *blocks.Blocks, kek *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(kek)
+  if err != nil {
+    return nil, err
+  }
+  if key_data.Len() % key_wrap_semiblock != 0 ||
+      key_data.Len() < 2 * key_wrap_semiblock {
+    return nil, fmt.Errorf(
+        "%w Key wrap needs a multiple of %d bytes, at least %d, got %d.",
+        ErrPartialBlock, key_wrap_semiblock, 2 * key_wrap_semiblock,
+        key_data.Len())
+  }
+  return blocks.FromBytes(modes.key_wrap(key_wrap_iv, key_data.ToBytes())), nil
+}
+
+
+/**
+ * Unwraps output from KeyWrap. Returns ErrIntegrity if the IV check fails,
+ * as it does for the wrong KEK or tampered input.
+ */
+func KeyUnwrap(
+    wrapped *blocks.Blocks, kek *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(kek)
+  if err != nil {
+    return nil, err
+  }
+  if wrapped.Len() % key_wrap_semiblock != 0 ||
+      wrapped.Len() < 3 * key_wrap_semiblock {
+    return nil, fmt.Errorf(
+        "%w Wrapped keys are a multiple of %d bytes, at least %d, got %d.",
+        ErrPartialBlock, key_wrap_semiblock, 3 * key_wrap_semiblock,
+        wrapped.Len())
+  }
+  iv, key_data := modes.key_unwrap(wrapped.ToBytes())
+  if !bytes.Equal(iv, key_wrap_iv) {
+    return nil, ErrIntegrity
+  }
+  return blocks.FromBytes(key_data), nil
+}
+
+
+/** Wraps key data of any non-zero length, padding it with zeros. */
+func KeyWrapPadded(
+    key_data *blocks.Blocks, kek *blocks.Blocks) *blocks.Blocks {
+  return must(KeyWrapPaddedE(key_data, kek))
+}
+
+
+/** Like KeyWrapPadded, but returns an error for a bad KEK or empty data. */
+func KeyWrapPaddedE(
+    key_data *blocks.Blocks, kek *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(kek)
+  if err != nil {
+    return nil, err
+  }
+  if key_data.Empty() || uint64(key_data.Len()) >> 32 != 0 {
+    return nil, fmt.Errorf(
+        "%w Padded key wrap needs 1 to 2^32-1 bytes, got %d.",
+        ErrPartialBlock, key_data.Len())
+  }
+  // The alternative IV holds the unpadded length.
+  iv := make([]byte, key_wrap_semiblock)
+  copy(iv, key_wrap_padded_iv_prefix)
+  binary.BigEndian.PutUint32(iv[4:], uint32(key_data.Len()))
+  padded := key_data.Copy()
+  zero_pad(padded, key_wrap_semiblock)
+
+  if padded.Len() == key_wrap_semiblock {
+    // A single semiblock is encrypted with the IV as one AES block.
+    block := append(iv, padded.ToBytes()...)
+    modes.block_cipher.Encrypt(block, block)
+    return blocks.FromBytes(block), nil
+  }
+  return blocks.FromBytes(modes.key_wrap(iv, padded.ToBytes())), nil
+}
+
+
+/**
+ * Unwraps output from KeyWrapPadded. Returns ErrIntegrity if the IV, length
+ * or padding check fails.
+ */
+func KeyUnwrapPadded(
+    wrapped *blocks.Blocks, kek *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(kek)
+  if err != nil {
+    return nil, err
+  }
+  if wrapped.Len() % key_wrap_semiblock != 0 ||
+      wrapped.Len() < 2 * key_wrap_semiblock {
+    return nil, fmt.Errorf(
+        "%w Wrapped keys are a multiple of %d bytes, at least %d, got %d.",
+        ErrPartialBlock, key_wrap_semiblock, 2 * key_wrap_semiblock,
+        wrapped.Len())
+  }
+  var iv, padded []byte
+  if wrapped.Len() == 2 * key_wrap_semiblock {
+    block := make([]byte, 2 * key_wrap_semiblock)
+    modes.block_cipher.Decrypt(block, wrapped.ToBytes())
+    iv, padded = block[:key_wrap_semiblock], block[key_wrap_semiblock:]
+  } else {
+    iv, padded = modes.key_unwrap(wrapped.ToBytes())
+  }
+  if !bytes.Equal(iv[:4], key_wrap_padded_iv_prefix) {
+    return nil, ErrIntegrity
+  }
+  length := int(binary.BigEndian.Uint32(iv[4:]))
+  if length > len(padded) || length <= len(padded) - key_wrap_semiblock {
+    return nil, ErrIntegrity
+  }
+  for _, b := range padded[length:] {
+    if b != 0 {
+      return nil, ErrIntegrity
+    }
+  }
+  return blocks.FromBytes(padded[:length]), nil
+}
+
+
+/**
+ * The wrapping function W: six passes over the semiblocks, each step
+ * encrypting A || R[i] and folding the step count into A.
+ */
+func (m *Modes) key_wrap(iv []byte, key_data []byte) []byte {
+  n := len(key_data) / key_wrap_semiblock
+  a := append([]byte(nil), iv...)
+  r := append([]byte(nil), key_data...)
+  block := make([]byte, 2 * key_wrap_semiblock)
+  for j := 0; j < 6; j++ {
+    for i := 0; i < n; i++ {
+      r_i := r[i * key_wrap_semiblock:(i + 1) * key_wrap_semiblock]
+      copy(block, a)
+      copy(block[key_wrap_semiblock:], r_i)
+      m.block_cipher.Encrypt(block, block)
+      t := uint64(n * j + i + 1)
+      binary.BigEndian.PutUint64(
+          a, binary.BigEndian.Uint64(block[:key_wrap_semiblock]) ^ t)
+      copy(r_i, block[key_wrap_semiblock:])
+    }
+  }
+  return append(a, r...)
+}
+
+
+/** The unwrapping function W^-1, returning the recovered IV and key data. */
+func (m *Modes) key_unwrap(wrapped []byte) ([]byte, []byte) {
+  n := len(wrapped) / key_wrap_semiblock - 1
+  a := append([]byte(nil), wrapped[:key_wrap_semiblock]...)
+  r := append([]byte(nil), wrapped[key_wrap_semiblock:]...)
+  block := make([]byte, 2 * key_wrap_semiblock)
+  for j := 5; j >= 0; j-- {
+    for i := n - 1; i >= 0; i-- {
+      r_i := r[i * key_wrap_semiblock:(i + 1) * key_wrap_semiblock]
+      t := uint64(n * j + i + 1)
+      binary.BigEndian.PutUint64(block, binary.BigEndian.Uint64(a) ^ t)
+      copy(block[key_wrap_semiblock:], r_i)
+      m.block_cipher.Decrypt(block, block)
+      copy(a, block[:key_wrap_semiblock])
+      copy(r_i, block[key_wrap_semiblock:])
+    }
+  }
+  return a, r
+}
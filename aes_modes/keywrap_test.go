@@ -0,0 +1,109 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestKeyWrapRfc3394(t *testing.T) {
+  // Sections 4.1 (128-bit KEK, 128-bit key) and 4.6 (256-bit both).
+  for i, vector := range []struct{
+      kek, key_data, wrapped string
+  }{
+      {"000102030405060708090a0b0c0d0e0f",
+       "00112233445566778899aabbccddeeff",
+       "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"},
+      {"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+       "00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f",
+       "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326" +
+       "cbc7f0e71a99f43bfb988b9b7a02dd21"},
+  } {
+    kek := blocks.FromHex(vector.kek)
+    key_data := blocks.FromHex(vector.key_data)
+    wrapped := KeyWrap(key_data, kek)
+    if wrapped.ToHex() != vector.wrapped {
+      t.Errorf(
+          "Vector %d: expected %s but got %s.",
+          i, vector.wrapped, wrapped.ToHex())
+    }
+    unwrapped, err := KeyUnwrap(wrapped, kek)
+    if err != nil || !blocks.Equal(unwrapped, key_data) {
+      t.Errorf("Vector %d did not round-trip: %v.", i, err)
+    }
+  }
+}
+
+
+func TestKeyWrapPaddedRfc5649(t *testing.T) {
+  // Section 6: 20 bytes, and 7 bytes (a single AES block).
+  kek := blocks.FromHex("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+  for i, vector := range []struct{
+      key_data, wrapped string
+  }{
+      {"c37b7e6492584340bed12207808941155068f738",
+       "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+      {"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+  } {
+    key_data := blocks.FromHex(vector.key_data)
+    wrapped := KeyWrapPadded(key_data, kek)
+    if wrapped.ToHex() != vector.wrapped {
+      t.Errorf(
+          "Vector %d: expected %s but got %s.",
+          i, vector.wrapped, wrapped.ToHex())
+    }
+    unwrapped, err := KeyUnwrapPadded(wrapped, kek)
+    if err != nil || !blocks.Equal(unwrapped, key_data) {
+      t.Errorf("Vector %d did not round-trip: %v.", i, err)
+    }
+  }
+}
+
+
+func TestKeyUnwrapIntegrity(t *testing.T) {
+  kek := blocks.FromHex("000102030405060708090a0b0c0d0e0f")
+  other_kek := blocks.FromHex("000102030405060708090a0b0c0d0e0e")
+  key_data := blocks.FromHex("00112233445566778899aabbccddeeff")
+  wrapped := KeyWrap(key_data, kek)
+  if _, err := KeyUnwrap(wrapped, other_kek); !errors.Is(err, ErrIntegrity) {
+    t.Errorf("Wrong KEK gave %v.", err)
+  }
+  for i := 0; i < wrapped.Len(); i++ {
+    tampered := wrapped.Copy().ToBytes()
+    tampered[i] ^= 1
+    _, err := KeyUnwrap(blocks.FromBytes(tampered), kek)
+    if !errors.Is(err, ErrIntegrity) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  // Padded and unpadded wrapping use different IVs, so do not mix.
+  if _, err := KeyUnwrapPadded(wrapped, kek); !errors.Is(err, ErrIntegrity) {
+    t.Errorf("Unwrapping as padded gave %v.", err)
+  }
+  for length := 1; length <= 24; length++ {
+    padded_wrapped := KeyWrapPadded(blocks.RepeatByte(0x5a, length), kek)
+    _, err := KeyUnwrapPadded(padded_wrapped, other_kek)
+    if !errors.Is(err, ErrIntegrity) {
+      t.Errorf("Length %d with the wrong KEK gave %v.", length, err)
+    }
+  }
+}
+
+
+func TestKeyWrapLengths(t *testing.T) {
+  kek := blocks.FromHex("000102030405060708090a0b0c0d0e0f")
+  for _, length := range []int{0, 8, 12, 17} {
+    _, err := KeyWrapE(blocks.RepeatByte(0, length), kek)
+    if !errors.Is(err, ErrPartialBlock) {
+      t.Errorf("Wrapping %d bytes gave %v.", length, err)
+    }
+  }
+  if _, err := KeyWrapPaddedE(blocks.New(), kek); !errors.Is(
+      err, ErrPartialBlock) {
+    t.Errorf("Padded wrapping of nothing gave %v.", err)
+  }
+  if _, err := KeyUnwrap(blocks.RepeatByte(0, 16), kek); !errors.Is(
+      err, ErrPartialBlock) {
+    t.Errorf("Unwrapping 16 bytes gave %v.", err)
+  }
+}
@@ -0,0 +1,292 @@
+/**
+ * Propagating CBC (PCBC), as in Kerberos v4, and Infinite Garble Extension
+ * (IGE), as in Telegram's MTProto. Both chain on the previous plaintext as
+ * well as the previous ciphertext.
+ * https://en.wikipedia.org/wiki/Block_cipher_mode_of_operation#PCBC
+ */
+
+package aes_modes
+
+import "fmt"
+
+import "../blocks"
+
+
+/** Encrypts using AES in PCBC mode, adding PKCS#7 padding. */
+func PcbcEncrypt(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(PcbcEncryptE(plaintext, key, iv))
+}
+
+
+/** Like PcbcEncrypt, but returns an error for a bad key or IV. */
+func PcbcEncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.PcbcEncryptE(plaintext, iv)
+}
+
+
+/** Encrypts using AES in PCBC mode, without padding. */
+func PcbcEncryptUnpadded(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).PcbcEncryptUnpadded(plaintext, iv)
+}
+
+
+/** Decrypts using AES in PCBC mode, removing PKCS#7 padding. */
+func PcbcDecrypt(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(PcbcDecryptE(ciphertext, key, iv))
+}
+
+
+/**
+ * Like PcbcDecrypt, but returns an error for a bad key or IV, partial blocks,
+ * or invalid padding.
+ */
+func PcbcDecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.PcbcDecryptE(ciphertext, iv)
+}
+
+
+/** Decrypts using AES in PCBC mode, leaving any padding in place. */
+func PcbcDecryptUnpadded(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return get_modes(key).PcbcDecryptUnpadded(ciphertext, iv)
+}
+
+
+/**
+ * Encrypts using AES in IGE mode. The IV is two blocks: the first stands in
+ * for the previous ciphertext block and the second for the previous plaintext
+ * block, as in OpenSSL. IGE does not pad; protocols using it pad themselves.
+ */
+func IgeEncrypt(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(IgeEncryptE(plaintext, key, iv))
+}
+
+
+/**
+ * Like IgeEncrypt, but returns an error for a bad key or IV, or partial
+ * blocks.
+ */
+func IgeEncryptE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.IgeEncryptE(plaintext, iv)
+}
+
+
+func IgeDecrypt(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(IgeDecryptE(ciphertext, key, iv))
+}
+
+
+func IgeDecryptE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.IgeDecryptE(ciphertext, iv)
+}
+
+
+/** PCBC-encrypts with this cipher, adding PKCS#7 padding. */
+func (m *Modes) PcbcEncrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.PcbcEncryptE(plaintext, iv))
+}
+
+
+func (m *Modes) PcbcEncryptE(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  return m.pcbc_encrypt(plaintext.PadPKCS7(m.BlockSize()), iv)
+}
+
+
+func (m *Modes) PcbcEncryptUnpadded(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.pcbc_encrypt(plaintext, iv))
+}
+
+
+/** Chains on the XOR of the previous plaintext and ciphertext blocks. */
+func (m *Modes) pcbc_encrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext = m.in_blocks(plaintext)
+  if err := m.validate_full_blocks(plaintext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  ciphertext := m.new_blocks()
+  chain := iv
+  for i := 0; i < plaintext.NumBlocks(); i++ {
+    plain_block := plaintext.Block(i)
+    cipher_block := make([]byte, m.BlockSize())
+    m.block_cipher.Encrypt(cipher_block, plain_block.Xor(chain).ToBytes())
+    ciphertext.AppendBytes(cipher_block)
+    chain = plain_block.Xor(blocks.FromBytes(cipher_block))
+  }
+  return ciphertext, nil
+}
+
+
+/** PCBC-decrypts with this cipher, removing PKCS#7 padding. */
+func (m *Modes) PcbcDecrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.PcbcDecryptE(ciphertext, iv))
+}
+
+
+func (m *Modes) PcbcDecryptE(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext, err := m.pcbc_decrypt(ciphertext, iv)
+  if err != nil {
+    return nil, err
+  }
+  return plaintext.UnpadPKCS7()
+}
+
+
+func (m *Modes) PcbcDecryptUnpadded(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.pcbc_decrypt(ciphertext, iv))
+}
+
+
+func (m *Modes) pcbc_decrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext = m.in_blocks(ciphertext)
+  if err := m.validate_full_blocks(ciphertext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  plaintext := m.new_blocks()
+  chain := iv
+  for i := 0; i < ciphertext.NumBlocks(); i++ {
+    cipher_block := ciphertext.Block(i)
+    decrypted := make([]byte, m.BlockSize())
+    m.block_cipher.Decrypt(decrypted, cipher_block.ToBytes())
+    plain_block := blocks.FromBytes(decrypted).Xor(chain)
+    plaintext.Append(plain_block)
+    chain = plain_block.Xor(cipher_block)
+  }
+  return plaintext, nil
+}
+
+
+/** Checks the IV is two blocks, as IGE needs. */
+func (m *Modes) validate_ige_iv(iv *blocks.Blocks) error {
+  if iv.Len() != 2 * m.BlockSize() {
+    return fmt.Errorf(
+        "%w Got %d bytes, IGE needs %d.",
+        ErrInvalidIvSize, iv.Len(), 2 * m.BlockSize())
+  }
+  return nil
+}
+
+
+/** IGE-encrypts with this cipher. The IV is two blocks. */
+func (m *Modes) IgeEncrypt(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.IgeEncryptE(plaintext, iv))
+}
+
+
+/** Encrypts each block as E(p[i] ^ c[i-1]) ^ p[i-1]. */
+func (m *Modes) IgeEncryptE(
+    plaintext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  plaintext = m.in_blocks(plaintext)
+  if err := m.validate_full_blocks(plaintext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_ige_iv(iv); err != nil {
+    return nil, err
+  }
+  ciphertext := m.new_blocks()
+  prev_cipher_block := blocks.FromBytes(iv.ToBytes()[:m.BlockSize()])
+  prev_plain_block := iv.Slice(m.BlockSize())
+  for i := 0; i < plaintext.NumBlocks(); i++ {
+    plain_block := plaintext.Block(i)
+    encrypted := make([]byte, m.BlockSize())
+    m.block_cipher.Encrypt(
+        encrypted, plain_block.Xor(prev_cipher_block).ToBytes())
+    cipher_block := blocks.FromBytes(encrypted).Xor(prev_plain_block)
+    ciphertext.Append(cipher_block)
+    prev_cipher_block = cipher_block
+    prev_plain_block = plain_block
+  }
+  return ciphertext, nil
+}
+
+
+func (m *Modes) IgeDecrypt(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) *blocks.Blocks {
+  return must(m.IgeDecryptE(ciphertext, iv))
+}
+
+
+/** Decrypts each block as D(c[i] ^ p[i-1]) ^ c[i-1]. */
+func (m *Modes) IgeDecryptE(
+    ciphertext *blocks.Blocks, iv *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext = m.in_blocks(ciphertext)
+  if err := m.validate_full_blocks(ciphertext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_ige_iv(iv); err != nil {
+    return nil, err
+  }
+  plaintext := m.new_blocks()
+  prev_cipher_block := blocks.FromBytes(iv.ToBytes()[:m.BlockSize()])
+  prev_plain_block := iv.Slice(m.BlockSize())
+  for i := 0; i < ciphertext.NumBlocks(); i++ {
+    cipher_block := ciphertext.Block(i)
+    decrypted := make([]byte, m.BlockSize())
+    m.block_cipher.Decrypt(
+        decrypted, cipher_block.Xor(prev_plain_block).ToBytes())
+    plain_block := blocks.FromBytes(decrypted).Xor(prev_cipher_block)
+    plaintext.Append(plain_block)
+    prev_cipher_block = cipher_block
+    prev_plain_block = plain_block
+  }
+  return plaintext, nil
+}
@@ -0,0 +1,105 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestPcbcRoundTrip(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.RepeatByte(0x42, 16)
+  for length := 0; length <= 48; length++ {
+    plaintext := blocks.RepeatByte('p', length)
+    ciphertext := PcbcEncrypt(plaintext, key, iv)
+    if !blocks.Equal(PcbcDecrypt(ciphertext, key, iv), plaintext) {
+      t.Errorf("Length %d did not round-trip.", length)
+    }
+  }
+}
+
+
+func TestPcbcFirstBlockMatchesCbc(t *testing.T) {
+  // Until the chaining includes a previous plaintext block, PCBC is CBC.
+  plaintext := nist_plaintext
+  pcbc := PcbcEncryptUnpadded(plaintext, nist_key, nist_iv)
+  cbc := CbcEncryptUnpadded(plaintext, nist_key, nist_iv)
+  if !blocks.Equal(pcbc.Block(0), cbc.Block(0)) {
+    t.Errorf(
+        "First blocks differ: %s vs %s.",
+        pcbc.Block(0).ToHex(), cbc.Block(0).ToHex())
+  }
+  if blocks.Equal(pcbc.Block(1), cbc.Block(1)) {
+    t.Errorf("PCBC and CBC agree past the first block.")
+  }
+}
+
+
+func TestPcbcBlockSwap(t *testing.T) {
+  // Swapping two adjacent ciphertext blocks garbles just those two blocks:
+  // the chaining value after them is p[i]^c[i]^p[i+1]^c[i+1] either way, so
+  // decryption recovers from then on. Kerberos v4 relied on PCBC to detect
+  // tampering, which this defeats.
+  plaintext := nist_plaintext
+  ciphertext := PcbcEncryptUnpadded(plaintext, nist_key, nist_iv)
+  swapped := blocks.New()
+  swapped.Append(ciphertext.Block(0))
+  swapped.Append(ciphertext.Block(2))
+  swapped.Append(ciphertext.Block(1))
+  swapped.Append(ciphertext.Block(3))
+  decrypted := PcbcDecryptUnpadded(swapped, nist_key, nist_iv)
+  if !blocks.Equal(decrypted.Block(0), plaintext.Block(0)) {
+    t.Errorf("Block before the swap was garbled.")
+  }
+  for _, i := range []int{1, 2} {
+    if blocks.Equal(decrypted.Block(i), plaintext.Block(i)) {
+      t.Errorf("Swapped block %d decrypted correctly.", i)
+    }
+  }
+  if !blocks.Equal(decrypted.Block(3), plaintext.Block(3)) {
+    t.Errorf(
+        "Block after the swap was garbled: expected %s but got %s.",
+        plaintext.Block(3).ToHex(), decrypted.Block(3).ToHex())
+  }
+}
+
+
+func TestIgeOpenssl(t *testing.T) {
+  // From OpenSSL's test/igetest.c.
+  key := blocks.FromHex("000102030405060708090a0b0c0d0e0f")
+  iv := blocks.FromHex(
+      "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+  plaintext := blocks.RepeatByte(0, 32)
+  expected := "1a8519a6557be652e9da8e43da4ef445" +
+      "3cf456b4ca488aa383c79c98b34797cb"
+  ciphertext := IgeEncrypt(plaintext, key, iv)
+  if ciphertext.ToHex() != expected {
+    t.Errorf("Expected %s but got %s.", expected, ciphertext.ToHex())
+  }
+  if !blocks.Equal(IgeDecrypt(ciphertext, key, iv), plaintext) {
+    t.Errorf("Did not round-trip.")
+  }
+}
+
+
+func TestIgeErrorPropagation(t *testing.T) {
+  // A flipped ciphertext bit garbles every following plaintext block.
+  key := nist_key
+  iv := blocks.RepeatByte(0x42, 32)
+  ciphertext := IgeEncrypt(nist_plaintext, key, iv).ToBytes()
+  ciphertext[0] ^= 1
+  decrypted := IgeDecrypt(blocks.FromBytes(ciphertext), key, iv)
+  for i := 0; i < decrypted.NumBlocks(); i++ {
+    if blocks.Equal(decrypted.Block(i), nist_plaintext.Block(i)) {
+      t.Errorf("Block %d was not garbled.", i)
+    }
+  }
+}
+
+
+func TestIgeInvalidIvSize(t *testing.T) {
+  _, err := IgeEncryptE(nist_plaintext, nist_key, nist_iv)
+  if !errors.Is(err, ErrInvalidIvSize) {
+    t.Errorf("Expected ErrInvalidIvSize but got %v.", err)
+  }
+}
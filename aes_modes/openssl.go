@@ -0,0 +1,113 @@
+/**
+ * OpenSSL `enc` compatible password-based encryption: the "Salted__" header
+ * with an 8-byte salt, and EVP_BytesToKey key and IV derivation. Equivalent to
+ * (without -pbkdf2, and with -md md5 for OpenSSL before 1.1.0):
+   openssl enc -aes-128-cbc -md sha256 -pass pass:PASSWORD -in t.txt
+ */
+
+package aes_modes
+
+import "crypto/aes"
+import "errors"
+import "fmt"
+import "hash"
+
+import "../blocks"
+
+
+const OpensslSaltSize int = 8
+const openssl_magic string = "Salted__"
+// The Salted__ magic and the salt, before the ciphertext.
+const OpensslHeaderSize int = len(openssl_magic) + OpensslSaltSize
+
+
+var ErrNotSalted = errors.New("Input lacks OpenSSL's Salted__ header.")
+
+
+/**
+ * Derives a key and IV from a password and salt as OpenSSL's EVP_BytesToKey
+ * does with one iteration: D_i = H(D_(i-1) || password || salt), concatenated
+ * until there are enough bytes for the key and then the IV.
+ */
+func EvpBytesToKey(
+    password *blocks.Blocks,
+    salt *blocks.Blocks,
+    new_hash func() hash.Hash,
+    key_size int,
+    iv_size int) (*blocks.Blocks, *blocks.Blocks) {
+  derived := blocks.New()
+  var prev_digest []byte
+  for derived.Len() < key_size + iv_size {
+    h := new_hash()
+    h.Write(prev_digest)
+    h.Write(password.ToBytes())
+    h.Write(salt.ToBytes())
+    prev_digest = h.Sum(nil)
+    derived.AppendBytes(prev_digest)
+  }
+  derived_bytes := derived.ToBytes()
+  key := blocks.FromBytes(derived_bytes[:key_size]).Copy()
+  iv := blocks.FromBytes(derived_bytes[key_size:key_size + iv_size]).Copy()
+  return key, iv
+}
+
+
+/** Returns "Salted__" || salt || ciphertext. */
+func OpensslFrame(
+    salt *blocks.Blocks, ciphertext *blocks.Blocks) *blocks.Blocks {
+  framed := blocks.FromString(openssl_magic)
+  framed.Append(salt)
+  framed.Append(ciphertext)
+  return framed
+}
+
+
+/** Splits OpenSSL's salted format into the salt and the ciphertext. */
+func OpensslUnframe(
+    framed *blocks.Blocks) (*blocks.Blocks, *blocks.Blocks, error) {
+  data := framed.ToBytes()
+  if len(data) < OpensslHeaderSize ||
+      string(data[:len(openssl_magic)]) != openssl_magic {
+    return nil, nil, ErrNotSalted
+  }
+  salt := blocks.FromBytes(data[len(openssl_magic):OpensslHeaderSize]).Copy()
+  return salt, framed.Slice(OpensslHeaderSize), nil
+}
+
+
+/**
+ * Encrypts as `openssl enc -aes-N-cbc -pass` does, with a key_size-byte key
+ * derived from the password and salt. Returns the Salted__ framed ciphertext.
+ */
+func OpensslCbcEncrypt(
+    plaintext *blocks.Blocks,
+    password *blocks.Blocks,
+    salt *blocks.Blocks,
+    key_size int,
+    new_hash func() hash.Hash) (*blocks.Blocks, error) {
+  if salt.Len() != OpensslSaltSize {
+    return nil, fmt.Errorf(
+        "Salt is %d bytes, OpenSSL uses %d.", salt.Len(), OpensslSaltSize)
+  }
+  key, iv := EvpBytesToKey(password, salt, new_hash, key_size, aes.BlockSize)
+  ciphertext, err := CbcEncryptE(plaintext, key, iv)
+  if err != nil {
+    return nil, err
+  }
+  return OpensslFrame(salt, ciphertext), nil
+}
+
+
+/** Decrypts output of `openssl enc -aes-N-cbc -pass` or OpensslCbcEncrypt. */
+func OpensslCbcDecrypt(
+    framed *blocks.Blocks,
+    password *blocks.Blocks,
+    key_size int,
+    new_hash func() hash.Hash) (*blocks.Blocks, error) {
+  salt, ciphertext, err := OpensslUnframe(framed)
+  if err != nil {
+    return nil, err
+  }
+  key, iv := EvpBytesToKey(password, salt, new_hash, key_size, aes.BlockSize)
+  return CbcDecryptE(ciphertext, key, iv)
+}
@@ -0,0 +1,83 @@
+package aes_modes
+
+import "crypto/md5"
+import "crypto/sha256"
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestEvpBytesToKey(t *testing.T) {
+  // openssl enc -aes-128-cbc -md md5 -pass pass:YELLOW -S 0102030405060708 -P
+  key, iv := EvpBytesToKey(
+      blocks.FromString("YELLOW"),
+      blocks.FromHex("0102030405060708"),
+      md5.New, 16, 16)
+  if key.ToHex() != "616a88aaded1c2bcb9ac375195225174" ||
+      iv.ToHex() != "fb7723e80da740efaf5a820a2f13677b" {
+    t.Errorf("MD5: got key %s, iv %s.", key.ToHex(), iv.ToHex())
+  }
+  // The same, with -aes-256-cbc -md sha256 -pass pass:submarine.
+  key, iv = EvpBytesToKey(
+      blocks.FromString("submarine"),
+      blocks.FromHex("a1b2c3d4e5f60718"),
+      sha256.New, 32, 16)
+  if key.ToHex() !=
+      "35890f232d5afa7b676e8cacebbdc7bd634e99424f89e735348cad51f535f503" ||
+      iv.ToHex() != "f58be59aebee623f5421176b4f7a2468" {
+    t.Errorf("SHA-256: got key %s, iv %s.", key.ToHex(), iv.ToHex())
+  }
+}
+
+
+func TestOpensslCbcRoundTrip(t *testing.T) {
+  for _, vector := range []struct{
+      framed_base64, password, plaintext string
+      key_size int
+      md5 bool
+  }{
+      // printf 'Rollin in my 5.0' | \
+      //   openssl enc -aes-128-cbc -md md5 -pass pass:YELLOW -a
+      {"U2FsdGVkX1+krMakuClImQER/4sRr33+6yqR6wKVFN74YjKecSLulmSqW/NMSufQ",
+       "YELLOW", "Rollin in my 5.0", 16, true},
+      // printf 'Ice, ice, baby.\n' | \
+      //   openssl enc -aes-256-cbc -md sha256 -pass pass:submarine -a
+      {"U2FsdGVkX1+wxjFahUKj2ApEA9ZRcVcddV/f1xqJWohIioeE2nKTzZEGFxRcZfmO",
+       "submarine", "Ice, ice, baby.\n", 32, false}} {
+    new_hash := sha256.New
+    if vector.md5 {
+      new_hash = md5.New
+    }
+    framed := blocks.FromBase64(vector.framed_base64)
+    password := blocks.FromString(vector.password)
+    plaintext, err := OpensslCbcDecrypt(
+        framed, password, vector.key_size, new_hash)
+    if err != nil || plaintext.ToString() != vector.plaintext {
+      t.Errorf(
+          "Expected decryption as %q, but got %v (%v).",
+          vector.plaintext, plaintext, err)
+      continue
+    }
+    salt, _, _ := OpensslUnframe(framed)
+    reencrypted, err := OpensslCbcEncrypt(
+        plaintext, password, salt, vector.key_size, new_hash)
+    if err != nil || !blocks.Equal(reencrypted, framed) {
+      t.Errorf(
+          "Expected encryption as %s, but got %v (%v).",
+          vector.framed_base64, reencrypted, err)
+    }
+  }
+}
+
+
+func TestOpensslUnsalted(t *testing.T) {
+  _, err := OpensslCbcDecrypt(
+      blocks.FromString("Unsalted, and too short"),
+      blocks.FromString("YELLOW"),
+      16,
+      md5.New)
+  if !errors.Is(err, ErrNotSalted) {
+    t.Errorf("Expected ErrNotSalted but got %v.", err)
+  }
+}
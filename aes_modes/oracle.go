@@ -0,0 +1,47 @@
+/**
+ * The ECB/CBC encryption oracle, with its random choices recorded, so that a
+ * mode detector can be scored against the truth rather than only logging it.
+ * https://cryptopals.com/sets/2/challenges/11
+ */
+
+package aes_modes
+
+import "crypto/aes"
+
+import "../blocks"
+
+
+/** The ciphertext from the oracle, and how it was made. */
+type OracleResult struct {
+  Ciphertext *blocks.Blocks
+  Mode Mode  // ModeEcb or ModeCbc
+  Key *blocks.Blocks
+  Iv *blocks.Blocks  // Nil for ECB.
+  // Numbers of random bytes added before and after the plaintext.
+  PrefixLen, SuffixLen int
+}
+
+
+/**
+ * Encrypts as RandomEncryptFrom does, and returns the random choices along
+ * with the ciphertext. This is ground truth for testing detectors; an attack
+ * should only look at the ciphertext.
+ */
+func RandomEncryptOracle(
+    r blocks.Rand, raw_plaintext *blocks.Blocks) *OracleResult {
+  result := &OracleResult{Key: blocks.RandomBlockFrom(r, aes.BlockSize)}
+  result.PrefixLen = 5 + r.Intn(5)
+  plaintext := blocks.RandomBlockFrom(r, result.PrefixLen)
+  plaintext.Append(raw_plaintext)
+  result.SuffixLen = 5 + r.Intn(5)
+  plaintext.Append(blocks.RandomBlockFrom(r, result.SuffixLen))
+  if r.Intn(2) > 0 {
+    result.Mode = ModeEcb
+    result.Ciphertext = EcbEncrypt(plaintext, result.Key)
+  } else {
+    result.Mode = ModeCbc
+    result.Iv = blocks.RandomBlockFrom(r, aes.BlockSize)
+    result.Ciphertext = CbcEncrypt(plaintext, result.Key, result.Iv)
+  }
+  return result
+}
@@ -0,0 +1,39 @@
+package aes_modes
+
+import "testing"
+
+import "../blocks"
+
+
+func TestOracleGroundTruth(t *testing.T) {
+  plaintext := blocks.RepeatByte('a', 48)
+  r := blocks.NewSeededRand(3)
+  seen := map[Mode]bool{}
+  for i := 0; i < 100; i++ {
+    result := RandomEncryptOracle(r, plaintext)
+    seen[result.Mode] = true
+    if result.PrefixLen < 5 || result.PrefixLen > 9 ||
+        result.SuffixLen < 5 || result.SuffixLen > 9 {
+      t.Fatalf(
+          "Random padding of %d and %d bytes is out of range.",
+          result.PrefixLen, result.SuffixLen)
+    }
+    var decrypted *blocks.Blocks
+    if result.Mode == ModeEcb {
+      decrypted = EcbDecrypt(result.Ciphertext, result.Key)
+    } else {
+      decrypted = CbcDecrypt(result.Ciphertext, result.Key, result.Iv)
+    }
+    if decrypted.Len() != result.PrefixLen + 48 + result.SuffixLen {
+      t.Fatalf("Decrypted %d bytes, not matching the result.", decrypted.Len())
+    }
+    middle := decrypted.ToBytes()[result.PrefixLen:result.PrefixLen + 48]
+    if !blocks.Equal(blocks.FromBytes(middle), plaintext) {
+      t.Fatalf("The plaintext is not where the result says: %q.", middle)
+    }
+  }
+  if !seen[ModeEcb] || !seen[ModeCbc] {
+    t.Errorf("Expected both modes in 100 trials, saw %v.", seen)
+  }
+}
+
@@ -0,0 +1,298 @@
+/**
+ * Parallel versions of the modes whose blocks are independent: ECB, CTR, and
+ * CBC decryption (each plaintext block needs only two ciphertext blocks). The
+ * text is split into chunks of whole blocks, which a pool of goroutines
+ * en/decrypts directly into one output buffer.
+ *
+ * The block cipher must be safe for concurrent use, as crypto/aes is.
+ */
+
+package aes_modes
+
+import "runtime"
+import "sync"
+
+import "../blocks"
+
+
+/** Blocks per job handed to a worker. */
+const parallel_chunk_blocks int = 4096
+
+
+/**
+ * Runs crypt over the text in chunks on a pool of workers (by default, one per
+ * CPU), and returns the output. crypt is given the index of the chunk's first
+ * block, and writes dst from src.
+ */
+func (m *Modes) parallel(
+    text []byte,
+    workers int,
+    crypt func(first_block int, dst []byte, src []byte)) []byte {
+  if workers <= 0 {
+    workers = runtime.GOMAXPROCS(0)
+  }
+  out := make([]byte, len(text))
+  chunk_size := parallel_chunk_blocks * m.BlockSize()
+  jobs := make(chan int)
+  var wg sync.WaitGroup
+  for w := 0; w < workers; w++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for start := range jobs {
+        end := start + chunk_size
+        if end > len(text) {
+          end = len(text)
+        }
+        crypt(start / m.BlockSize(), out[start:end], text[start:end])
+      }
+    }()
+  }
+  for start := 0; start < len(text); start += chunk_size {
+    jobs <- start
+  }
+  close(jobs)
+  wg.Wait()
+  return out
+}
+
+
+/** Like EcbEncrypt, but splits the work across workers (0 for one per CPU). */
+func EcbEncryptParallel(
+    plaintext *blocks.Blocks, key *blocks.Blocks, workers int) *blocks.Blocks {
+  return must(EcbEncryptParallelE(plaintext, key, workers))
+}
+
+
+func EcbEncryptParallelE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    workers int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.EcbEncryptParallelE(plaintext, workers)
+}
+
+
+/** Like EcbDecrypt, but splits the work across workers (0 for one per CPU). */
+func EcbDecryptParallel(
+    ciphertext *blocks.Blocks, key *blocks.Blocks, workers int) *blocks.Blocks {
+  return must(EcbDecryptParallelE(ciphertext, key, workers))
+}
+
+
+func EcbDecryptParallelE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    workers int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.EcbDecryptParallelE(ciphertext, workers)
+}
+
+
+/** Like CtrEncrypt, but splits the work across workers (0 for one per CPU). */
+func CtrEncryptParallel(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) *blocks.Blocks {
+  return must(CtrEncryptParallelE(text, key, nonce, format, workers))
+}
+
+
+func CtrEncryptParallelE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CtrEncryptParallelE(text, nonce, format, workers)
+}
+
+
+func CtrDecryptParallel(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) *blocks.Blocks {
+  return CtrEncryptParallel(text, key, nonce, format, workers)
+}
+
+
+func CtrDecryptParallelE(
+    text *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) (*blocks.Blocks, error) {
+  return CtrEncryptParallelE(text, key, nonce, format, workers)
+}
+
+
+/** Like CbcDecrypt, but splits the work across workers (0 for one per CPU). */
+func CbcDecryptParallel(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    workers int) *blocks.Blocks {
+  return must(CbcDecryptParallelE(ciphertext, key, iv, workers))
+}
+
+
+func CbcDecryptParallelE(
+    ciphertext *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks,
+    workers int) (*blocks.Blocks, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.CbcDecryptParallelE(ciphertext, iv, workers)
+}
+
+
+/** ECB-encrypts with this cipher in parallel, adding PKCS#7 padding. */
+func (m *Modes) EcbEncryptParallel(
+    plaintext *blocks.Blocks, workers int) *blocks.Blocks {
+  return must(m.EcbEncryptParallelE(plaintext, workers))
+}
+
+
+func (m *Modes) EcbEncryptParallelE(
+    plaintext *blocks.Blocks, workers int) (*blocks.Blocks, error) {
+  return m.ecb_parallel(plaintext.PadPKCS7(m.BlockSize()), workers, false)
+}
+
+
+/** ECB-decrypts with this cipher in parallel, removing PKCS#7 padding. */
+func (m *Modes) EcbDecryptParallel(
+    ciphertext *blocks.Blocks, workers int) *blocks.Blocks {
+  return must(m.EcbDecryptParallelE(ciphertext, workers))
+}
+
+
+func (m *Modes) EcbDecryptParallelE(
+    ciphertext *blocks.Blocks, workers int) (*blocks.Blocks, error) {
+  plaintext, err := m.ecb_parallel(ciphertext, workers, true)
+  if err != nil {
+    return nil, err
+  }
+  return plaintext.UnpadPKCS7()
+}
+
+
+func (m *Modes) ecb_parallel(
+    text *blocks.Blocks, workers int, decrypt bool) (*blocks.Blocks, error) {
+  if err := m.validate_full_blocks(text); err != nil {
+    return nil, err
+  }
+  block_size := m.BlockSize()
+  out := m.parallel(
+      text.ToBytes(),
+      workers,
+      func(first_block int, dst []byte, src []byte) {
+        for i := 0; i < len(src); i += block_size {
+          if decrypt {
+            m.block_cipher.Decrypt(dst[i:i + block_size], src[i:i + block_size])
+          } else {
+            m.block_cipher.Encrypt(dst[i:i + block_size], src[i:i + block_size])
+          }
+        }
+      })
+  return m.wrap_output(out), nil
+}
+
+
+/** En/decrypts with this cipher in CTR mode, in parallel. */
+func (m *Modes) CtrEncryptParallel(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) *blocks.Blocks {
+  return must(m.CtrEncryptParallelE(text, nonce, format, workers))
+}
+
+
+func (m *Modes) CtrEncryptParallelE(
+    text *blocks.Blocks,
+    nonce *blocks.Blocks,
+    format CounterFormat,
+    workers int) (*blocks.Blocks, error) {
+  initial, err := format.initial_counter(nonce, m.BlockSize())
+  if err != nil {
+    return nil, err
+  }
+  block_size := m.BlockSize()
+  out := m.parallel(
+      text.ToBytes(),
+      workers,
+      func(first_block int, dst []byte, src []byte) {
+        // Each chunk starts its own counter at its first block.
+        counter := append([]byte(nil), initial...)
+        format.advance(counter, uint64(first_block))
+        keystream := make([]byte, block_size)
+        for start := 0; start < len(src); start += block_size {
+          m.block_cipher.Encrypt(keystream, counter)
+          end := start + block_size
+          if end > len(src) {
+            end = len(src)
+          }
+          copy(dst[start:end], src[start:end])
+          blocks.XorInto(dst[start:end], keystream)
+          format.increment(counter)
+        }
+      })
+  return m.wrap_output(out), nil
+}
+
+
+/** CBC-decrypts with this cipher in parallel, removing PKCS#7 padding. */
+func (m *Modes) CbcDecryptParallel(
+    ciphertext *blocks.Blocks,
+    iv *blocks.Blocks,
+    workers int) *blocks.Blocks {
+  return must(m.CbcDecryptParallelE(ciphertext, iv, workers))
+}
+
+
+func (m *Modes) CbcDecryptParallelE(
+    ciphertext *blocks.Blocks,
+    iv *blocks.Blocks,
+    workers int) (*blocks.Blocks, error) {
+  if err := m.validate_full_blocks(ciphertext); err != nil {
+    return nil, err
+  }
+  if err := m.validate_iv(iv); err != nil {
+    return nil, err
+  }
+  block_size := m.BlockSize()
+  cipher_bytes := ciphertext.ToBytes()
+  out := m.parallel(
+      cipher_bytes,
+      workers,
+      func(first_block int, dst []byte, src []byte) {
+        for start := 0; start < len(src); start += block_size {
+          block := first_block + start / block_size
+          prev := iv.ToBytes()
+          if block > 0 {
+            prev = cipher_bytes[(block - 1) * block_size:block * block_size]
+          }
+          plain_block := dst[start:start + block_size]
+          m.block_cipher.Decrypt(plain_block, src[start:start + block_size])
+          blocks.XorInto(plain_block, prev)
+        }
+      })
+  return m.wrap_output(out).UnpadPKCS7()
+}
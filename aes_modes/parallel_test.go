@@ -0,0 +1,155 @@
+package aes_modes
+
+import "sync"
+import "testing"
+
+import "../blocks"
+
+
+/** Returns length bytes which differ from block to block. */
+func counting_text(length int) *blocks.Blocks {
+  text := make([]byte, length)
+  for i := range text {
+    text[i] = byte(i * 7 + i / 251)
+  }
+  return blocks.FromBytes(text)
+}
+
+
+func TestParallelMatchesSerial(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.RepeatByte(0x42, 16)
+  chunk := parallel_chunk_blocks * 16
+  // Empty, within one chunk, exactly at and across chunk boundaries.
+  for _, length := range []int{
+      0, 1, 16, 100, chunk - 1, chunk, chunk + 5, 3 * chunk + 17} {
+    plaintext := counting_text(length)
+    for _, workers := range []int{0, 1, 3} {
+      ecb := EcbEncryptParallel(plaintext, key, workers)
+      if !blocks.Equal(ecb, EcbEncrypt(plaintext, key)) {
+        t.Errorf("ECB length %d, %d workers differs.", length, workers)
+      }
+      if !blocks.Equal(EcbDecryptParallel(ecb, key, workers), plaintext) {
+        t.Errorf(
+            "ECB length %d, %d workers did not round-trip.", length, workers)
+      }
+      cbc := CbcEncrypt(plaintext, key, iv)
+      if !blocks.Equal(CbcDecryptParallel(cbc, key, iv, workers), plaintext) {
+        t.Errorf("CBC length %d, %d workers differs.", length, workers)
+      }
+      for _, format := range []CounterFormat{
+          CounterLittleEndian64, CounterBigEndian128} {
+        nonce := blocks.RepeatByte(0xff, format.nonce_size(16))
+        ctr := CtrEncryptParallel(plaintext, key, nonce, format, workers)
+        if !blocks.Equal(ctr, CtrEncrypt(plaintext, key, nonce, format)) {
+          t.Errorf(
+              "CTR format %d length %d, %d workers differs.",
+              format, length, workers)
+        }
+      }
+    }
+  }
+}
+
+
+func TestCounterAdvance(t *testing.T) {
+  for _, format := range []CounterFormat{
+      CounterLittleEndian64, CounterBigEndian128} {
+    for _, n := range []uint64{0, 1, 255, 256, 65537} {
+      advanced := make([]byte, 16)
+      for i := range advanced {
+        advanced[i] = 0xf0 + byte(i)
+      }
+      incremented := append([]byte(nil), advanced...)
+      format.advance(advanced, n)
+      for i := uint64(0); i < n; i++ {
+        format.increment(incremented)
+      }
+      if !blocks.Equal(
+          blocks.FromBytes(advanced), blocks.FromBytes(incremented)) {
+        t.Errorf(
+            "Format %d, advancing %d gave %x, incrementing gave %x.",
+            format, n, advanced, incremented)
+      }
+    }
+  }
+}
+
+
+var benchmark_text *blocks.Blocks
+var benchmark_text_once sync.Once
+var benchmark_key = blocks.FromString("YELLOW SUBMARINE")
+var benchmark_iv = blocks.RepeatByte(0x42, 16)
+
+
+/**
+ * Returns 4 MiB of input for benchmarks, built on first use so that plain test
+ * runs don't pay for it, and resets the timer.
+ */
+func benchmark_input(b *testing.B) *blocks.Blocks {
+  benchmark_text_once.Do(func() {
+    benchmark_text = counting_text(4 << 20)
+  })
+  b.ResetTimer()
+  return benchmark_text
+}
+
+
+func BenchmarkEcbEncryptSerial(b *testing.B) {
+  text := benchmark_input(b)
+  b.SetBytes(int64(text.Len()))
+  for i := 0; i < b.N; i++ {
+    EcbEncrypt(text, benchmark_key)
+  }
+}
+
+
+func BenchmarkEcbEncryptParallel(b *testing.B) {
+  text := benchmark_input(b)
+  b.SetBytes(int64(text.Len()))
+  for i := 0; i < b.N; i++ {
+    EcbEncryptParallel(text, benchmark_key, 0)
+  }
+}
+
+
+func BenchmarkCtrSerial(b *testing.B) {
+  text := benchmark_input(b)
+  nonce := blocks.RepeatByte(0, 8)
+  b.SetBytes(int64(text.Len()))
+  for i := 0; i < b.N; i++ {
+    CtrEncrypt(text, benchmark_key, nonce, CounterLittleEndian64)
+  }
+}
+
+
+func BenchmarkCtrParallel(b *testing.B) {
+  text := benchmark_input(b)
+  nonce := blocks.RepeatByte(0, 8)
+  b.SetBytes(int64(text.Len()))
+  for i := 0; i < b.N; i++ {
+    CtrEncryptParallel(text, benchmark_key, nonce, CounterLittleEndian64, 0)
+  }
+}
+
+
+func BenchmarkCbcDecryptSerial(b *testing.B) {
+  text := benchmark_input(b)
+  ciphertext := CbcEncrypt(text, benchmark_key, benchmark_iv)
+  b.SetBytes(int64(ciphertext.Len()))
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    CbcDecrypt(ciphertext, benchmark_key, benchmark_iv)
+  }
+}
+
+
+func BenchmarkCbcDecryptParallel(b *testing.B) {
+  text := benchmark_input(b)
+  ciphertext := CbcEncrypt(text, benchmark_key, benchmark_iv)
+  b.SetBytes(int64(ciphertext.Len()))
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    CbcDecryptParallel(ciphertext, benchmark_key, benchmark_iv, 0)
+  }
+}
@@ -0,0 +1,328 @@
+/**
+ * Nonce-misuse resistant authenticated encryption: AES-SIV (RFC 5297) and
+ * AES-GCM-SIV (RFC 8452). Both derive the CTR IV from a MAC of the plaintext,
+ * so repeating a nonce only reveals whether the same message was sent, rather
+ * than the XOR of plaintexts (as with CTR) or the authentication key (as with
+ * GCM).
+ */
+
+package aes_modes
+
+import "crypto/aes"
+import "crypto/subtle"
+import "encoding/binary"
+import "fmt"
+
+import "../blocks"
+
+
+const SivTagSize int = 16
+const GcmSivNonceSize int = 12
+
+
+/**
+ * Splits a double-length SIV key into the S2V (CMAC) key and the CTR key.
+ */
+func siv_keys(key *blocks.Blocks) (*Modes, *Modes, error) {
+  if key.Len() != 32 && key.Len() != 48 && key.Len() != 64 {
+    return nil, nil, fmt.Errorf(
+        "%w SIV needs a 32, 48 or 64 byte (double-length) key, got %d.",
+        ErrInvalidKeySize, key.Len())
+  }
+  half := key.Len() / 2
+  mac_modes := get_modes(blocks.FromBytes(key.ToBytes()[:half]))
+  ctr_modes := get_modes(blocks.FromBytes(key.ToBytes()[half:]))
+  return mac_modes, ctr_modes, nil
+}
+
+
+/**
+ * S2V: a CMAC-based PRF over a vector of strings, the associated data and
+ * then the plaintext. Each string is CMACed separately, so they are not
+ * ambiguous when concatenated.
+ */
+func (m *Modes) s2v(
+    additional_data []*blocks.Blocks, plaintext []byte) []byte {
+  d := m.cmac(make([]byte, aes.BlockSize))
+  for _, data := range additional_data {
+    d = cmac_double(d)
+    mac := m.cmac(data.ToBytes())
+    for i := range d {
+      d[i] ^= mac[i]
+    }
+  }
+  var t []byte
+  if len(plaintext) >= aes.BlockSize {
+    // XOR d into the end of the plaintext.
+    t = append([]byte(nil), plaintext...)
+    end := t[len(t) - aes.BlockSize:]
+    for i := range end {
+      end[i] ^= d[i]
+    }
+  } else {
+    t = cmac_double(d)
+    padded := make([]byte, aes.BlockSize)
+    copy(padded, plaintext)
+    padded[len(plaintext)] = 0x80
+    for i := range t {
+      t[i] ^= padded[i]
+    }
+  }
+  return m.cmac(t)
+}
+
+
+/** Returns the CTR counter for the synthetic IV, with two bits cleared. */
+func siv_counter(v []byte) *blocks.Blocks {
+  q := append([]byte(nil), v...)
+  q[8] &= 0x7f
+  q[12] &= 0x7f
+  return blocks.FromBytes(q)
+}
+
+
+/**
+ * Deterministically encrypts with a 32, 48 or 64 byte key, returning the
+ * synthetic IV (the tag) followed by the ciphertext. Any number of associated
+ * data strings are authenticated; to use a nonce, pass it as the last one.
+ */
+func SivSeal(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    additional_data ...*blocks.Blocks) *blocks.Blocks {
+  return must(SivSealE(plaintext, key, additional_data...))
+}
+
+
+/** Like SivSeal, but returns an error for a bad key. */
+func SivSealE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    additional_data ...*blocks.Blocks) (*blocks.Blocks, error) {
+  mac_modes, ctr_modes, err := siv_keys(key)
+  if err != nil {
+    return nil, err
+  }
+  v := mac_modes.s2v(additional_data, plaintext.ToBytes())
+  ciphertext, err := ctr_modes.CtrEncryptE(
+      plaintext, siv_counter(v), CounterBigEndian128)
+  if err != nil {
+    return nil, err
+  }
+  sealed := blocks.FromBytes(v)
+  sealed.Append(ciphertext)
+  return sealed, nil
+}
+
+
+/**
+ * Decrypts and verifies (in constant time) output from SivSeal. Returns
+ * ErrAuthentication, and no plaintext, if verification fails.
+ */
+func SivOpen(
+    sealed *blocks.Blocks,
+    key *blocks.Blocks,
+    additional_data ...*blocks.Blocks) (*blocks.Blocks, error) {
+  mac_modes, ctr_modes, err := siv_keys(key)
+  if err != nil {
+    return nil, err
+  }
+  if sealed.Len() < SivTagSize {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for a tag.", ErrAuthentication, sealed.Len())
+  }
+  v := sealed.ToBytes()[:SivTagSize]
+  plaintext, err := ctr_modes.CtrEncryptE(
+      sealed.Slice(SivTagSize), siv_counter(v), CounterBigEndian128)
+  if err != nil {
+    return nil, err
+  }
+  expected := mac_modes.s2v(additional_data, plaintext.ToBytes())
+  if subtle.ConstantTimeCompare(expected, v) != 1 {
+    return nil, ErrAuthentication
+  }
+  return plaintext, nil
+}
+
+
+func reverse_bytes(in []byte) []byte {
+  out := make([]byte, len(in))
+  for i, b := range in {
+    out[len(in) - 1 - i] = b
+  }
+  return out
+}
+
+
+/**
+ * Computes POLYVAL (RFC 8452 section 3) with key h over data, zero-padded to
+ * whole blocks. POLYVAL is GHASH with the bytes reversed: it is evaluated
+ * here with GHASH's multiplication, with h first multiplied by x.
+ */
+func Polyval(h *blocks.Blocks, data *blocks.Blocks) *blocks.Blocks {
+  check_gf_size(h)
+  return blocks.FromBytes(polyval(h.ToBytes(), data.ToBytes()))
+}
+
+
+func polyval(h []byte, data []byte) []byte {
+  h_element := gf_from_bytes(reverse_bytes(h))
+  // Multiply by x: one shift (toward the high powers) in GCM's bit order.
+  reduce := h_element.lo & 1 != 0
+  h_element.lo = h_element.lo >> 1 | h_element.hi << 63
+  h_element.hi >>= 1
+  if reduce {
+    h_element.hi ^= 0xe1 << 56
+  }
+  var s gf_element
+  for start := 0; start < len(data); start += gcm_block_size {
+    block := make([]byte, gcm_block_size)
+    copy(block, data[start:])
+    x := gf_from_bytes(reverse_bytes(block))
+    s = gf_element{hi: s.hi ^ x.hi, lo: s.lo ^ x.lo}.mul(h_element)
+  }
+  return reverse_bytes(s.to_bytes())
+}
+
+
+/**
+ * Derives the per-nonce POLYVAL key and encryption Modes from the
+ * key-generating key: the first 8 bytes of each AES_K(LE32(i) || nonce).
+ */
+func gcm_siv_keys(
+    key *blocks.Blocks, nonce *blocks.Blocks) ([]byte, *Modes, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, nil, err
+  }
+  if key.Len() != 16 && key.Len() != 32 {
+    return nil, nil, fmt.Errorf(
+        "%w GCM-SIV needs a 16 or 32 byte key, got %d.",
+        ErrInvalidKeySize, key.Len())
+  }
+  if nonce.Len() != GcmSivNonceSize {
+    return nil, nil, fmt.Errorf(
+        "%w GCM-SIV needs a %d byte nonce, got %d.",
+        ErrInvalidNonceSize, GcmSivNonceSize, nonce.Len())
+  }
+  derived := []byte{}
+  input := make([]byte, aes.BlockSize)
+  copy(input[4:], nonce.ToBytes())
+  output := make([]byte, aes.BlockSize)
+  for i := 0; len(derived) < 16 + key.Len(); i++ {
+    binary.LittleEndian.PutUint32(input, uint32(i))
+    modes.block_cipher.Encrypt(output, input)
+    derived = append(derived, output[:8]...)
+  }
+  return derived[:16], get_modes(blocks.FromBytes(derived[16:])), nil
+}
+
+
+/** Computes the tag from POLYVAL over the padded data and lengths. */
+func (m *Modes) gcm_siv_tag(
+    auth_key []byte,
+    nonce []byte,
+    additional_data []byte,
+    plaintext []byte) []byte {
+  pad := func(data []byte) []byte {
+    blocks_needed := (len(data) + gcm_block_size - 1) / gcm_block_size
+    padded := make([]byte, blocks_needed * gcm_block_size)
+    copy(padded, data)
+    return padded
+  }
+  input := pad(additional_data)
+  input = append(input, pad(plaintext)...)
+  lengths := make([]byte, gcm_block_size)
+  binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additional_data)) * 8)
+  binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext)) * 8)
+  input = append(input, lengths...)
+
+  s := polyval(auth_key, input)
+  for i := range nonce {
+    s[i] ^= nonce[i]
+  }
+  s[15] &= 0x7f
+  m.block_cipher.Encrypt(s, s)
+  return s
+}
+
+
+/**
+ * Encrypts or decrypts in GCM-SIV's CTR mode: the counter starts at the tag
+ * with its top bit set, and its first 32 bits are a little-endian count.
+ */
+func (m *Modes) gcm_siv_ctr(text []byte, tag []byte) []byte {
+  counter := append([]byte(nil), tag...)
+  counter[15] |= 0x80
+  out := make([]byte, len(text))
+  keystream := make([]byte, gcm_block_size)
+  for start := 0; start < len(text); start += gcm_block_size {
+    m.block_cipher.Encrypt(keystream, counter)
+    for i := start; i < len(text) && i < start + gcm_block_size; i++ {
+      out[i] = text[i] ^ keystream[i - start]
+    }
+    binary.LittleEndian.PutUint32(
+        counter, binary.LittleEndian.Uint32(counter) + 1)
+  }
+  return out
+}
+
+
+/**
+ * Encrypts with a 16 or 32 byte key and a 12 byte nonce, returning the
+ * ciphertext followed by the tag.
+ */
+func GcmSivSeal(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) *blocks.Blocks {
+  return must(GcmSivSealE(plaintext, key, nonce, additional_data))
+}
+
+
+/** Like GcmSivSeal, but returns an error for a bad key or nonce. */
+func GcmSivSealE(
+    plaintext *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  auth_key, enc_modes, err := gcm_siv_keys(key, nonce)
+  if err != nil {
+    return nil, err
+  }
+  tag := enc_modes.gcm_siv_tag(
+      auth_key, nonce.ToBytes(), additional_data.ToBytes(), plaintext.ToBytes())
+  sealed := blocks.FromBytes(enc_modes.gcm_siv_ctr(plaintext.ToBytes(), tag))
+  sealed.AppendBytes(tag)
+  return sealed, nil
+}
+
+
+/**
+ * Decrypts and verifies (in constant time) output from GcmSivSeal. Returns
+ * ErrAuthentication, and no plaintext, if verification fails.
+ */
+func GcmSivOpen(
+    sealed *blocks.Blocks,
+    key *blocks.Blocks,
+    nonce *blocks.Blocks,
+    additional_data *blocks.Blocks) (*blocks.Blocks, error) {
+  auth_key, enc_modes, err := gcm_siv_keys(key, nonce)
+  if err != nil {
+    return nil, err
+  }
+  if sealed.Len() < GcmTagSize {
+    return nil, fmt.Errorf(
+        "%w %d bytes is too short for a tag.", ErrAuthentication, sealed.Len())
+  }
+  split := sealed.Len() - GcmTagSize
+  tag := sealed.ToBytes()[split:]
+  plaintext := enc_modes.gcm_siv_ctr(sealed.ToBytes()[:split], tag)
+  expected := enc_modes.gcm_siv_tag(
+      auth_key, nonce.ToBytes(), additional_data.ToBytes(), plaintext)
+  if subtle.ConstantTimeCompare(expected, tag) != 1 {
+    return nil, ErrAuthentication
+  }
+  return blocks.FromBytes(plaintext), nil
+}
@@ -0,0 +1,176 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestSivRfc5297(t *testing.T) {
+  // A.1, deterministic authenticated encryption.
+  key := blocks.FromHex(
+      "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+  additional_data := blocks.FromHex(
+      "101112131415161718191a1b1c1d1e1f2021222324252627")
+  plaintext := blocks.FromHex("112233445566778899aabbccddee")
+  expected := "85632d07c6e8f37f950acd320a2ecc93" +
+      "40c02b9690c4dc04daef7f6afe5c"
+  sealed := SivSeal(plaintext, key, additional_data)
+  if sealed.ToHex() != expected {
+    t.Errorf("Expected %s but got %s.", expected, sealed.ToHex())
+  }
+  opened, err := SivOpen(sealed, key, additional_data)
+  if err != nil || !blocks.Equal(opened, plaintext) {
+    t.Errorf("Did not round-trip: %v.", err)
+  }
+}
+
+
+func TestSivRfc5297Nonce(t *testing.T) {
+  // A.2, with two associated data strings and a nonce.
+  key := blocks.FromHex(
+      "7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f")
+  additional_data := []*blocks.Blocks{
+      blocks.FromHex(
+          "00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa9988" +
+          "7766554433221100"),
+      blocks.FromHex("102030405060708090a0"),
+      blocks.FromHex("09f911029d74e35bd84156c5635688c0"),
+  }
+  plaintext := blocks.FromHex(
+      "7468697320697320736f6d6520706c61696e7465787420746f20656e63727970" +
+      "74207573696e67205349562d414553")
+  expected := "7bdb6e3b432667eb06f4d14bff2fbd0f" +
+      "cb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829" +
+      "ea64ad544a272e9c485b62a3fd5c0d"
+  sealed := SivSeal(plaintext, key, additional_data...)
+  if sealed.ToHex() != expected {
+    t.Errorf("Expected %s but got %s.", expected, sealed.ToHex())
+  }
+  opened, err := SivOpen(sealed, key, additional_data...)
+  if err != nil || !blocks.Equal(opened, plaintext) {
+    t.Errorf("Did not round-trip: %v.", err)
+  }
+  // The order and division of the associated data both matter.
+  reordered := []*blocks.Blocks{
+      additional_data[1], additional_data[0], additional_data[2]}
+  if _, err := SivOpen(sealed, key, reordered...); !errors.Is(
+      err, ErrAuthentication) {
+    t.Errorf("Reordered associated data gave %v.", err)
+  }
+}
+
+
+func TestSivNonceMisuse(t *testing.T) {
+  // Without a nonce, equal plaintexts give equal output, and nothing more
+  // leaks: different plaintexts are unrelated, unlike in CTR.
+  key := blocks.RepeatByte(0x42, 32)
+  a := blocks.RepeatByte('a', 32)
+  b := blocks.RepeatByte('b', 32)
+  if !blocks.Equal(SivSeal(a, key), SivSeal(a, key)) {
+    t.Errorf("SIV was not deterministic.")
+  }
+  xored := SivSeal(a, key).Slice(SivTagSize).Xor(
+      SivSeal(b, key).Slice(SivTagSize))
+  if blocks.Equal(xored, a.Xor(b)) {
+    t.Errorf("Ciphertexts XOR to the plaintexts' XOR, as in reused CTR.")
+  }
+}
+
+
+func TestSivRejectsTampering(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 64)
+  ad := blocks.FromString("header")
+  sealed := SivSeal(blocks.FromString("Ice, ice, baby."), key, ad)
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 1
+    if _, err := SivOpen(blocks.FromBytes(tampered), key, ad); !errors.Is(
+        err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  if _, err := SivOpen(sealed, key, blocks.FromString("Header")); !errors.Is(
+      err, ErrAuthentication) {
+    t.Errorf("Changed associated data gave %v.", err)
+  }
+  if _, err := SivSealE(ad, blocks.RepeatByte(0x42, 16)); !errors.Is(
+      err, ErrInvalidKeySize) {
+    t.Errorf("Single-length key gave %v.", err)
+  }
+}
+
+
+func TestPolyvalRfc8452(t *testing.T) {
+  // Appendix A.
+  h := blocks.FromHex("25629347589242761d31f826ba4b757b")
+  data := blocks.FromHex(
+      "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+  result := Polyval(h, data)
+  if result.ToHex() != "f7a3b47b846119fae5b7866cf5e5b77e" {
+    t.Errorf("Got %s.", result.ToHex())
+  }
+}
+
+
+func TestGcmSivRfc8452(t *testing.T) {
+  // Appendix C.1 (AES-128) and C.2 (AES-256).
+  key128 := blocks.FromHex("01000000000000000000000000000000")
+  key256 := blocks.FromHex(
+      "0100000000000000000000000000000000000000000000000000000000000000")
+  nonce := blocks.FromHex("030000000000000000000000")
+  for i, vector := range []struct{
+      key *blocks.Blocks
+      additional_data, plaintext, sealed string
+  }{
+      {key128, "", "", "dc20e2d83f25705bb49e439eca56de25"},
+      {key128, "", "0100000000000000",
+       "b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+      {key128, "", "010000000000000000000000",
+       "7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639"},
+      {key128, "", "01000000000000000000000000000000",
+       "743f7c8077ab25f8624e2e948579cf77303aaf90f6fe21199c6068577437a0c4"},
+      {key128, "01", "0200000000000000",
+       "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508"},
+      {key256, "", "", "07f5f4169bbf55a8400cd47ea6fd400f"},
+      {key256, "", "0100000000000000",
+       "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+  } {
+    additional_data := blocks.FromHex(vector.additional_data)
+    plaintext := blocks.FromHex(vector.plaintext)
+    sealed := GcmSivSeal(plaintext, vector.key, nonce, additional_data)
+    if sealed.ToHex() != vector.sealed {
+      t.Errorf(
+          "Vector %d: expected %s but got %s.",
+          i, vector.sealed, sealed.ToHex())
+    }
+    opened, err := GcmSivOpen(sealed, vector.key, nonce, additional_data)
+    if err != nil || !blocks.Equal(opened, plaintext) {
+      t.Errorf("Vector %d did not round-trip: %v.", i, err)
+    }
+  }
+}
+
+
+func TestGcmSivRejectsTampering(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 16)
+  nonce := blocks.RepeatByte(0x24, GcmSivNonceSize)
+  ad := blocks.FromString("header")
+  sealed := GcmSivSeal(blocks.FromString("Ice, ice, baby."), key, nonce, ad)
+  for i := 0; i < sealed.Len(); i++ {
+    tampered := sealed.Copy().ToBytes()
+    tampered[i] ^= 1
+    _, err := GcmSivOpen(blocks.FromBytes(tampered), key, nonce, ad)
+    if !errors.Is(err, ErrAuthentication) {
+      t.Errorf("Flipping byte %d gave %v.", i, err)
+    }
+  }
+  _, err := GcmSivSealE(ad, key, blocks.RepeatByte(0, 16), ad)
+  if !errors.Is(err, ErrInvalidNonceSize) {
+    t.Errorf("Expected ErrInvalidNonceSize but got %v.", err)
+  }
+  _, err = GcmSivSealE(ad, blocks.RepeatByte(0, 24), nonce, ad)
+  if !errors.Is(err, ErrInvalidKeySize) {
+    t.Errorf("Expected ErrInvalidKeySize but got %v.", err)
+  }
+}
@@ -0,0 +1,319 @@
+/**
+ * Streaming en/decryption with ECB, CBC or CTR, as an io.Writer and an
+ * io.Reader. Data is processed a block at a time as it arrives, so memory use
+ * does not grow with the length of the stream; only a partial block (and, when
+ * decrypting a padded mode, the last full block) is buffered.
+ */
+
+package aes_modes
+
+import "fmt"
+import "io"
+
+import "../blocks"
+
+
+/** A mode of operation which can be streamed. */
+type Mode int
+
+const (
+  ModeEcb Mode = iota
+  ModeCbc
+  ModeCtrLittleEndian64
+  ModeCtrBigEndian128
+)
+
+
+func (mode Mode) String() string {
+  switch mode {
+  case ModeEcb:
+    return "ECB"
+  case ModeCbc:
+    return "CBC"
+  case ModeCtrLittleEndian64:
+    return "CTR (64-bit little-endian counter)"
+  case ModeCtrBigEndian128:
+    return "CTR (128-bit big-endian counter)"
+  default:
+    return fmt.Sprintf("Mode(%d)", int(mode))
+  }
+}
+
+
+/** Whether the mode pads with PKCS#7 (rather than being a stream cipher). */
+func (mode Mode) padded() bool {
+  return mode == ModeEcb || mode == ModeCbc
+}
+
+
+/** The chaining state of one stream, applied to whole blocks in place. */
+type block_stream struct {
+  modes *Modes
+  mode Mode
+  decrypt bool
+  chain []byte  // CBC: the previous ciphertext block
+  counter []byte  // CTR: the next counter block
+  keystream []byte
+}
+
+
+func (m *Modes) new_block_stream(
+    mode Mode, iv *blocks.Blocks, decrypt bool) (*block_stream, error) {
+  s := &block_stream{modes: m, mode: mode, decrypt: decrypt}
+  switch mode {
+  case ModeEcb:
+  case ModeCbc:
+    if err := m.validate_iv(iv); err != nil {
+      return nil, err
+    }
+    s.chain = append([]byte(nil), iv.ToBytes()...)
+  case ModeCtrLittleEndian64, ModeCtrBigEndian128:
+    counter, err := s.counter_format().initial_counter(iv, m.BlockSize())
+    if err != nil {
+      return nil, err
+    }
+    s.counter = counter
+    s.keystream = make([]byte, m.BlockSize())
+  default:
+    return nil, fmt.Errorf("Cannot stream %s.", mode)
+  }
+  return s, nil
+}
+
+
+func (s *block_stream) counter_format() CounterFormat {
+  if s.mode == ModeCtrBigEndian128 {
+    return CounterBigEndian128
+  }
+  return CounterLittleEndian64
+}
+
+
+/**
+ * En/decrypts data in place. It must be whole blocks, except that in CTR
+ * mode the last call may end with a partial block.
+ */
+func (s *block_stream) crypt(data []byte) {
+  block_size := s.modes.BlockSize()
+  cipher := s.modes.block_cipher
+  for start := 0; start < len(data); start += block_size {
+    end := start + block_size
+    if end > len(data) {
+      end = len(data)
+    }
+    block := data[start:end]
+    switch {
+    case s.mode == ModeEcb && s.decrypt:
+      cipher.Decrypt(block, block)
+    case s.mode == ModeEcb:
+      cipher.Encrypt(block, block)
+    case s.mode == ModeCbc && s.decrypt:
+      next_chain := append(s.keystream[:0], block...)
+      cipher.Decrypt(block, block)
+      blocks.XorInto(block, s.chain)
+      s.keystream, s.chain = s.chain, next_chain
+    case s.mode == ModeCbc:
+      blocks.XorInto(block, s.chain)
+      cipher.Encrypt(block, block)
+      copy(s.chain, block)
+    default:
+      cipher.Encrypt(s.keystream, s.counter)
+      blocks.XorInto(block, s.keystream)
+      s.counter_format().increment(s.counter)
+    }
+  }
+}
+
+
+/** Encrypts everything written to it, writing the ciphertext to w. */
+type encrypt_writer struct {
+  w io.Writer
+  stream *block_stream
+  pending []byte  // a partial block, not yet encrypted
+  closed bool
+  // The first error from w. The stream state has moved past the bytes which
+  // failed, so every later Write and Close fails too.
+  err error
+}
+
+
+/**
+ * Returns a writer which encrypts with AES in the mode, and writes the
+ * ciphertext to w. iv is the CBC IV or CTR nonce, and is ignored for ECB.
+ * Close must be called to write the final (for ECB and CBC, padded) block; it
+ * does not close w.
+ */
+func NewEncryptWriter(
+    w io.Writer,
+    mode Mode,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (io.WriteCloser, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.NewEncryptWriter(w, mode, iv)
+}
+
+
+/** Returns a writer which encrypts with this cipher; see NewEncryptWriter. */
+func (m *Modes) NewEncryptWriter(
+    w io.Writer, mode Mode, iv *blocks.Blocks) (io.WriteCloser, error) {
+  stream, err := m.new_block_stream(mode, iv, false)
+  if err != nil {
+    return nil, err
+  }
+  return &encrypt_writer{w: w, stream: stream}, nil
+}
+
+
+func (e *encrypt_writer) Write(p []byte) (int, error) {
+  if e.err != nil {
+    return 0, e.err
+  }
+  if e.closed {
+    return 0, fmt.Errorf("Write after Close.")
+  }
+  e.pending = append(e.pending, p...)
+  block_size := e.stream.modes.BlockSize()
+  full := len(e.pending) / block_size * block_size
+  if full == 0 {
+    return len(p), nil
+  }
+  e.stream.crypt(e.pending[:full])
+  _, e.err = e.w.Write(e.pending[:full])
+  e.pending = append(e.pending[:0], e.pending[full:]...)
+  if e.err != nil {
+    return 0, e.err
+  }
+  return len(p), nil
+}
+
+
+/** Encrypts and writes the final block, with padding for ECB and CBC. */
+func (e *encrypt_writer) Close() error {
+  if e.err != nil {
+    return e.err
+  }
+  if e.closed {
+    return nil
+  }
+  e.closed = true
+  final := e.pending
+  if e.stream.mode.padded() {
+    padded := blocks.FromBytes(final).PadPKCS7(e.stream.modes.BlockSize())
+    final = padded.ToBytes()
+  }
+  e.stream.crypt(final)
+  _, e.err = e.w.Write(final)
+  return e.err
+}
+
+
+/** Decrypts everything read from r. */
+type decrypt_reader struct {
+  r io.Reader
+  stream *block_stream
+  buf []byte  // for reads from r, reused
+  in []byte  // ciphertext not yet decrypted
+  out []byte  // plaintext not yet returned
+  eof bool
+  err error
+}
+
+
+/**
+ * Returns a reader which decrypts what it reads from r with AES in the mode.
+ * For ECB and CBC, the padding is checked and removed at the end of the
+ * stream; invalid padding is reported as a *blocks.PaddingError, and a partial
+ * final block as ErrPartialBlock.
+ */
+func NewDecryptReader(
+    r io.Reader,
+    mode Mode,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (io.Reader, error) {
+  modes, err := ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.NewDecryptReader(r, mode, iv)
+}
+
+
+/** Returns a reader which decrypts with this cipher; see NewDecryptReader. */
+func (m *Modes) NewDecryptReader(
+    r io.Reader, mode Mode, iv *blocks.Blocks) (io.Reader, error) {
+  stream, err := m.new_block_stream(mode, iv, true)
+  if err != nil {
+    return nil, err
+  }
+  return &decrypt_reader{r: r, stream: stream}, nil
+}
+
+
+/** Bytes to read from the underlying reader at a time. */
+const stream_read_size int = 4096
+
+
+func (d *decrypt_reader) Read(p []byte) (int, error) {
+  for len(d.out) == 0 && d.err == nil {
+    d.fill()
+  }
+  if len(d.out) > 0 {
+    n := copy(p, d.out)
+    d.out = d.out[n:]
+    return n, nil
+  }
+  return 0, d.err
+}
+
+
+/** Reads more ciphertext, and decrypts as much of it as can be. */
+func (d *decrypt_reader) fill() {
+  block_size := d.stream.modes.BlockSize()
+  if !d.eof {
+    if d.buf == nil {
+      d.buf = make([]byte, stream_read_size)
+    }
+    n, err := d.r.Read(d.buf)
+    d.in = append(d.in, d.buf[:n]...)
+    if err == io.EOF {
+      d.eof = true
+    } else if err != nil {
+      d.err = err
+      return
+    }
+  }
+  ready := len(d.in) / block_size * block_size
+  if d.eof && !d.stream.mode.padded() {
+    ready = len(d.in)
+  } else if !d.eof && d.stream.mode.padded() && len(d.in) > 0 {
+    // Hold back the last full block, which may be padding, until the end.
+    ready = (len(d.in) - 1) / block_size * block_size
+  }
+  if d.eof && d.stream.mode.padded() && ready != len(d.in) {
+    d.err = fmt.Errorf(
+        "%w Stream ended %d bytes into a block.",
+        ErrPartialBlock, len(d.in) - ready)
+    return
+  }
+  plaintext := d.in[:ready]
+  d.stream.crypt(plaintext)
+  d.out = append(d.out[:0], plaintext...)
+  d.in = append(d.in[:0], d.in[ready:]...)
+  if d.eof {
+    if d.stream.mode.padded() {
+      unpadded := blocks.FromBytes(d.out)
+      unpadded.SetBlockSize(block_size)
+      stripped, err := unpadded.UnpadPKCS7()
+      if err != nil {
+        d.out = nil
+        d.err = err
+        return
+      }
+      d.out = stripped.ToBytes()
+    }
+    d.err = io.EOF
+  }
+}
@@ -0,0 +1,193 @@
+package aes_modes
+
+import "bytes"
+import "errors"
+import "io"
+import "io/ioutil"
+import "testing"
+import "testing/iotest"
+
+import "../blocks"
+
+
+var stream_modes = []struct{
+  mode Mode
+  iv *blocks.Blocks
+  encrypt func(text *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks
+}{
+  {ModeEcb, nil,
+   func(text *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+     return EcbEncrypt(text, key)
+   }},
+  {ModeCbc, nist_iv,
+   func(text *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+     return CbcEncrypt(text, key, nist_iv)
+   }},
+  {ModeCtrLittleEndian64, blocks.RepeatByte(0x24, 8),
+   func(text *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+     return CtrEncrypt(
+         text, key, blocks.RepeatByte(0x24, 8), CounterLittleEndian64)
+   }},
+  {ModeCtrBigEndian128, nist_iv,
+   func(text *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+     return CtrEncrypt(text, key, nist_iv, CounterBigEndian128)
+   }},
+}
+
+
+func TestEncryptWriterMatchesBlocks(t *testing.T) {
+  for _, stream_mode := range stream_modes {
+    for _, length := range []int{0, 1, 15, 16, 17, 100, 5000} {
+      plaintext := counting_text(length)
+      expected := stream_mode.encrypt(plaintext, nist_key)
+      // Write in awkward pieces, which do not line up with blocks.
+      for _, piece := range []int{1, 7, 16, 33, 10000} {
+        var out bytes.Buffer
+        w, err := NewEncryptWriter(
+            &out, stream_mode.mode, nist_key, stream_mode.iv)
+        if err != nil {
+          t.Fatal(err)
+        }
+        data := plaintext.ToBytes()
+        for start := 0; start < len(data); start += piece {
+          end := start + piece
+          if end > len(data) {
+            end = len(data)
+          }
+          w.Write(data[start:end])
+        }
+        if err := w.Close(); err != nil {
+          t.Fatal(err)
+        }
+        if !bytes.Equal(out.Bytes(), expected.ToBytes()) {
+          t.Errorf(
+              "%s, length %d, pieces of %d: got %d bytes, expected %d.",
+              stream_mode.mode, length, piece, out.Len(), expected.Len())
+        }
+      }
+    }
+  }
+}
+
+
+func TestDecryptReaderMatchesBlocks(t *testing.T) {
+  for _, stream_mode := range stream_modes {
+    for _, length := range []int{0, 1, 15, 16, 17, 100, 5000} {
+      plaintext := counting_text(length)
+      ciphertext := stream_mode.encrypt(plaintext, nist_key)
+      // Read one byte at a time from the ciphertext, to split blocks.
+      r, err := NewDecryptReader(
+          iotest.OneByteReader(bytes.NewReader(ciphertext.ToBytes())),
+          stream_mode.mode, nist_key, stream_mode.iv)
+      if err != nil {
+        t.Fatal(err)
+      }
+      decrypted, err := ioutil.ReadAll(r)
+      if err != nil || !bytes.Equal(decrypted, plaintext.ToBytes()) {
+        t.Errorf(
+            "%s, length %d: got %d bytes, error %v.",
+            stream_mode.mode, length, len(decrypted), err)
+      }
+    }
+  }
+}
+
+
+func TestDecryptReaderErrors(t *testing.T) {
+  ciphertext := CbcEncrypt(counting_text(40), nist_key, nist_iv).ToBytes()
+  read_all := func(data []byte) error {
+    r, err := NewDecryptReader(
+        bytes.NewReader(data), ModeCbc, nist_key, nist_iv)
+    if err != nil {
+      t.Fatal(err)
+    }
+    _, err = ioutil.ReadAll(r)
+    return err
+  }
+  if err := read_all(ciphertext[:len(ciphertext) - 3]); !errors.Is(
+      err, ErrPartialBlock) {
+    t.Errorf("Truncated stream gave %v.", err)
+  }
+  tampered := append([]byte(nil), ciphertext...)
+  tampered[len(tampered) - 17] ^= 0x02  // changes the final padding byte
+  var padding_error *blocks.PaddingError
+  if err := read_all(tampered); !errors.As(err, &padding_error) {
+    t.Errorf("Bad padding gave %v.", err)
+  }
+  _, err := NewDecryptReader(
+      bytes.NewReader(ciphertext), ModeCbc, nist_key, blocks.New())
+  if !errors.Is(err, ErrInvalidIvSize) {
+    t.Errorf("Missing IV gave %v.", err)
+  }
+}
+
+
+func TestEncryptWriterWriteAfterClose(t *testing.T) {
+  w, err := NewEncryptWriter(ioutil.Discard, ModeEcb, nist_key, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  w.Close()
+  if _, err := io.WriteString(w, "more"); err == nil {
+    t.Errorf("Write after Close succeeded.")
+  }
+}
+
+
+/** Fails its second write only, as after a transient error. */
+type flaky_writer struct {
+  writes int
+}
+
+
+var errWriteFailed = errors.New("write failed")
+
+
+func (f *flaky_writer) Write(p []byte) (int, error) {
+  f.writes++
+  if f.writes == 2 {
+    return 0, errWriteFailed
+  }
+  return len(p), nil
+}
+
+
+func TestEncryptWriterErrorIsSticky(t *testing.T) {
+  w, err := NewEncryptWriter(&flaky_writer{}, ModeCbc, nist_key, nist_iv)
+  if err != nil {
+    t.Fatal(err)
+  }
+  block := make([]byte, 16)
+  if _, err := w.Write(block); err != nil {
+    t.Fatalf("The first write failed: %v", err)
+  }
+  if _, err := w.Write(block); !errors.Is(err, errWriteFailed) {
+    t.Errorf("Expected the write to fail but got %v.", err)
+  }
+  // A retry must not write ciphertext from the wrong chaining state.
+  if n, err := w.Write(block); n != 0 || !errors.Is(err, errWriteFailed) {
+    t.Errorf("Expected the retry to fail but got %d, %v.", n, err)
+  }
+  if err := w.Close(); !errors.Is(err, errWriteFailed) {
+    t.Errorf("Expected Close to fail but got %v.", err)
+  }
+}
+
+
+func TestDecryptReaderReusesBuffer(t *testing.T) {
+  ciphertext := CbcEncrypt(counting_text(1000), nist_key, nist_iv)
+  // Each fill then reads one byte from the input.
+  input := iotest.OneByteReader(bytes.NewReader(ciphertext.ToBytes()))
+  r, err := NewDecryptReader(input, ModeCbc, nist_key, nist_iv)
+  if err != nil {
+    t.Fatal(err)
+  }
+  one := make([]byte, 1)
+  r.Read(one)
+  allocs := testing.AllocsPerRun(100, func() {
+    r.Read(one)
+  })
+  if allocs > 0 {
+    t.Errorf("Reading a byte at a time allocated %f times per read.", allocs)
+  }
+}
@@ -0,0 +1,162 @@
+/**
+ * AES-XTS, the IEEE 1619 disk encryption mode. Each sector is encrypted
+ * independently under a tweak derived from its sector number, with ciphertext
+ * stealing for sectors which are not a whole number of blocks.
+ * https://en.wikipedia.org/wiki/Disk_encryption_theory#XTS
+ */
+
+package aes_modes
+
+import "crypto/aes"
+import "encoding/binary"
+import "fmt"
+
+import "../blocks"
+
+
+/** Multiplies the tweak by x (alpha) in GF(2^128), little-endian. */
+func xts_next_tweak(tweak []byte) {
+  carry := byte(0)
+  for i := range tweak {
+    next_carry := tweak[i] >> 7
+    tweak[i] = tweak[i] << 1 | carry
+    carry = next_carry
+  }
+  if carry != 0 {
+    tweak[0] ^= 0x87
+  }
+}
+
+
+/**
+ * Splits the double-length key into the data and tweak ciphers, and returns
+ * the initial tweak for the sector.
+ */
+func xts_setup(
+    key *blocks.Blocks,
+    sector_number uint64,
+    sector *blocks.Blocks) (*Modes, []byte, error) {
+  if key.Len() != 32 && key.Len() != 64 {
+    return nil, nil, fmt.Errorf(
+        "%w XTS needs a 32 or 64 byte (double-length) key, got %d.",
+        ErrInvalidKeySize, key.Len())
+  }
+  if sector.Len() < aes.BlockSize {
+    return nil, nil, fmt.Errorf(
+        "%w XTS needs sectors of at least %d bytes, got %d.",
+        ErrPartialBlock, aes.BlockSize, sector.Len())
+  }
+  half := key.Len() / 2
+  data_modes := get_modes(blocks.FromBytes(key.ToBytes()[:half]))
+  tweak_modes := get_modes(blocks.FromBytes(key.ToBytes()[half:]))
+  tweak := make([]byte, aes.BlockSize)
+  binary.LittleEndian.PutUint64(tweak, sector_number)
+  tweak_modes.block_cipher.Encrypt(tweak, tweak)
+  return data_modes, tweak, nil
+}
+
+
+/** Encrypts or decrypts one block in place: XOR, cipher, XOR the tweak. */
+func (m *Modes) xts_block(block []byte, tweak []byte, decrypt bool) {
+  for i := range block {
+    block[i] ^= tweak[i]
+  }
+  if decrypt {
+    m.block_cipher.Decrypt(block, block)
+  } else {
+    m.block_cipher.Encrypt(block, block)
+  }
+  for i := range block {
+    block[i] ^= tweak[i]
+  }
+}
+
+
+/** Encrypts one sector with a 32 (AES-128) or 64 (AES-256) byte key. */
+func XtsEncryptSector(
+    sector *blocks.Blocks,
+    key *blocks.Blocks,
+    sector_number uint64) *blocks.Blocks {
+  return must(XtsEncryptSectorE(sector, key, sector_number))
+}
+
+
+/**
+ * Like XtsEncryptSector, but returns an error for a bad key or a sector
+ * shorter than one block.
+ */
+func XtsEncryptSectorE(
+    sector *blocks.Blocks,
+    key *blocks.Blocks,
+    sector_number uint64) (*blocks.Blocks, error) {
+  modes, tweak, err := xts_setup(key, sector_number, sector)
+  if err != nil {
+    return nil, err
+  }
+  data := sector.Copy().ToBytes()
+  full_blocks := len(data) / aes.BlockSize
+  partial_size := len(data) % aes.BlockSize
+  for i := 0; i < full_blocks; i++ {
+    modes.xts_block(
+        data[i * aes.BlockSize:(i + 1) * aes.BlockSize], tweak, false)
+    xts_next_tweak(tweak)
+  }
+  if partial_size > 0 {
+    // Steal the tail of the last full ciphertext block to fill out the
+    // partial block, and move that block's head to the end.
+    last_full := data[
+        (full_blocks - 1) * aes.BlockSize:full_blocks * aes.BlockSize]
+    partial := data[full_blocks * aes.BlockSize:]
+    merged := append([]byte(nil), partial...)
+    merged = append(merged, last_full[partial_size:]...)
+    copy(partial, last_full[:partial_size])
+    modes.xts_block(merged, tweak, false)
+    copy(last_full, merged)
+  }
+  return blocks.FromBytes(data), nil
+}
+
+
+func XtsDecryptSector(
+    sector *blocks.Blocks,
+    key *blocks.Blocks,
+    sector_number uint64) *blocks.Blocks {
+  return must(XtsDecryptSectorE(sector, key, sector_number))
+}
+
+
+func XtsDecryptSectorE(
+    sector *blocks.Blocks,
+    key *blocks.Blocks,
+    sector_number uint64) (*blocks.Blocks, error) {
+  modes, tweak, err := xts_setup(key, sector_number, sector)
+  if err != nil {
+    return nil, err
+  }
+  data := sector.Copy().ToBytes()
+  full_blocks := len(data) / aes.BlockSize
+  partial_size := len(data) % aes.BlockSize
+  plain_full_blocks := full_blocks
+  if partial_size > 0 {
+    plain_full_blocks--  // the last full block needs the final tweak first
+  }
+  for i := 0; i < plain_full_blocks; i++ {
+    modes.xts_block(
+        data[i * aes.BlockSize:(i + 1) * aes.BlockSize], tweak, true)
+    xts_next_tweak(tweak)
+  }
+  if partial_size > 0 {
+    prev_tweak := append([]byte(nil), tweak...)
+    xts_next_tweak(tweak)
+    last_full := data[
+        (full_blocks - 1) * aes.BlockSize:full_blocks * aes.BlockSize]
+    partial := data[full_blocks * aes.BlockSize:]
+    modes.xts_block(last_full, tweak, true)
+    merged := append([]byte(nil), partial...)
+    merged = append(merged, last_full[partial_size:]...)
+    copy(partial, last_full[:partial_size])
+    modes.xts_block(merged, prev_tweak, true)
+    copy(last_full, merged)
+  }
+  return blocks.FromBytes(data), nil
+}
@@ -0,0 +1,88 @@
+package aes_modes
+
+import "errors"
+import "testing"
+
+import "../blocks"
+
+
+func TestXtsIeee1619(t *testing.T) {
+  // IEEE 1619-2007 Annex B, vectors 1-3 and 15-18. The standard lists the
+  // data unit sequence number as little-endian bytes, so 9a78563412 is
+  // 0x123456789a.
+  key15 := "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0"
+  for i, vector := range []struct{
+      key string
+      sector_number uint64
+      plaintext, ciphertext string
+  }{
+      {"0000000000000000000000000000000000000000000000000000000000000000", 0,
+       "0000000000000000000000000000000000000000000000000000000000000000",
+       "917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e"},
+      {"1111111111111111111111111111111122222222222222222222222222222222",
+       0x3333333333,
+       "4444444444444444444444444444444444444444444444444444444444444444",
+       "c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0"},
+      {"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f022222222222222222222222222222222",
+       0x3333333333,
+       "4444444444444444444444444444444444444444444444444444444444444444",
+       "af85336b597afc1a900b2eb21ec949d292df4c047e0b21532186a5971a227a89"},
+      {key15, 0x123456789a,
+       "000102030405060708090a0b0c0d0e0f10",
+       "6c1625db4671522d3d7599601de7ca09ed"},
+      {key15, 0x123456789a,
+       "000102030405060708090a0b0c0d0e0f1011",
+       "d069444b7a7e0cab09e24447d24deb1fedbf"},
+      {key15, 0x123456789a,
+       "000102030405060708090a0b0c0d0e0f101112",
+       "e5df1351c0544ba1350b3363cd8ef4beedbf9d"},
+      {key15, 0x123456789a,
+       "000102030405060708090a0b0c0d0e0f10111213",
+       "9d84c813f719aa2c7be3f66171c7c5c2edbf9dac"},
+  } {
+    key := blocks.FromHex(vector.key)
+    plaintext := blocks.FromHex(vector.plaintext)
+    ciphertext := XtsEncryptSector(plaintext, key, vector.sector_number)
+    if ciphertext.ToHex() != vector.ciphertext {
+      t.Errorf(
+          "Vector %d: expected %s but got %s.",
+          i, vector.ciphertext, ciphertext.ToHex())
+    }
+    decrypted := XtsDecryptSector(ciphertext, key, vector.sector_number)
+    if !blocks.Equal(decrypted, plaintext) {
+      t.Errorf("Vector %d: decrypted as %s.", i, decrypted.ToHex())
+    }
+  }
+}
+
+
+func TestXtsSectorsAreIndependent(t *testing.T) {
+  key := blocks.RepeatByte(0x42, 64)
+  sector := blocks.FromString(rfc3962_plaintext)
+  first := XtsEncryptSector(sector, key, 1)
+  second := XtsEncryptSector(sector, key, 2)
+  if blocks.Equal(first.Block(0), second.Block(0)) {
+    t.Errorf("The same data in different sectors encrypted identically.")
+  }
+  // Within a sector each block has its own tweak, so equal plaintext blocks
+  // still encrypt differently.
+  repeated := blocks.RepeatByte('x', 32)
+  encrypted := XtsEncryptSector(repeated, key, 1)
+  if blocks.Equal(encrypted.Block(0), encrypted.Block(1)) {
+    t.Errorf("Repeated blocks within a sector encrypted identically.")
+  }
+}
+
+
+func TestXtsErrors(t *testing.T) {
+  _, err := XtsEncryptSectorE(
+      blocks.RepeatByte('x', 32), blocks.RepeatByte(0x42, 16), 0)
+  if !errors.Is(err, ErrInvalidKeySize) {
+    t.Errorf("Expected ErrInvalidKeySize but got %v.", err)
+  }
+  _, err = XtsEncryptSectorE(
+      blocks.RepeatByte('x', 15), blocks.RepeatByte(0x42, 32), 0)
+  if !errors.Is(err, ErrPartialBlock) {
+    t.Errorf("Expected ErrPartialBlock but got %v.", err)
+  }
+}
@@ -9,28 +9,39 @@
 package main
 
 import (
+    "crypto/aes"
     "log"
 
+    "github.com/droundy/goopt"
+
     "./blocks"
     "./aes_modes"
+    "./detect"
 )
 
 
 func main() {
+  var seed = goopt.Int([]string{"--seed"}, 0, blocks.SeedFlagHelp)
+  goopt.Description = func() string {
+    return "Detect whether a random encryption used ECB or CBC."
+  }
+  goopt.Parse(nil)
+  r, _ := blocks.SeededOrRandom(int64(*seed))
+
   plaintext := blocks.FromString(
       "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
-  ciphertext := aes_modes.RandomEncrypt(plaintext)
-  var mode_used string
-
-  min_dist, avg_dist := ciphertext.GetMinimumAndAverageHammingDistance()
-  log.Printf("Hamming Distance:\tmin: %f\tavg: %f", min_dist, avg_dist)
-
-  if min_dist <= 0.0 {
-    mode_used = "ECB"
-  } else {
-    mode_used = "CBC (by process of elimination)"
+  result := aes_modes.RandomEncryptOracle(r, plaintext)
+  ciphertext := result.Ciphertext
+  // With no repeated blocks, CBC is a guess by process of elimination.
+  mode_used, confidence := detect.DetectMode(ciphertext, aes.BlockSize)
+  log.Printf(
+      "Encrypted (%d bytes):\n%s\nEncryption mode determined to be %s " +
+      "(confidence %.2f).",
+      ciphertext.Len(), ciphertext.ToBase64(), mode_used, confidence)
+  if mode_used != result.Mode {
+    log.Fatalf("Wrong: the oracle used %s.", result.Mode)
   }
   log.Printf(
-      "Encrypted (%d bytes):\n%s\nEncryption mode determined to be %s.",
-      ciphertext.Len(), ciphertext.ToBase64(), mode_used)
+      "Correct: the oracle used %s (%d + %d random bytes around the input).",
+      result.Mode, result.PrefixLen, result.SuffixLen)
 }
@@ -8,15 +8,32 @@ package blocks
 import "encoding/base64"
 import "bufio"
 import "bytes"
+import "errors"
 import "fmt"
 import "io"
 import "math"
-import "crypto/rand"
 
 
 const default_block_size int = 16
 
 
+var ErrOddHex = errors.New("Hex input has an odd number of digits.")
+var ErrMismatchedLength = errors.New("Blocks have mismatched lengths.")
+var ErrBlockIndex = errors.New("Block index out of range.")
+
+
+/** Returned when parsing hex which contains a non-hex character. */
+type InvalidHexError struct {
+  Rune rune
+  Index int  // position of the rune in the input
+}
+
+
+func (e *InvalidHexError) Error() string {
+  return fmt.Sprintf("Rune %q at %d is invalid as hex.", e.Rune, e.Index)
+}
+
+
 type Blocks struct {
   block_size int
   buf bytes.Buffer
@@ -29,7 +46,8 @@ func New() *Blocks {
 
 
 func (b *Blocks) Copy() *Blocks {
-  cp := FromBytes(b.buf.Bytes())
+  // Copy the bytes, so appending to the copy cannot write into b's buffer.
+  cp := FromBytes(append([]byte(nil), b.buf.Bytes()...))
   cp.block_size = b.block_size
   return cp
 }
@@ -100,9 +118,16 @@ func FromBytesBuffer(input_buf bytes.Buffer) *Blocks {
 }
 
 
+/** Returns block_size bytes from CryptoRand. */
 func RandomBlock(block_size int) *Blocks {
+  return RandomBlockFrom(CryptoRand, block_size)
+}
+
+
+/** Returns block_size bytes from the given source. */
+func RandomBlockFrom(r Rand, block_size int) *Blocks {
   buf := make([]byte, block_size)
-  _, err := rand.Read(buf)
+  _, err := io.ReadFull(r, buf)
   if err != nil {
     panic(err)
   }
@@ -137,6 +162,20 @@ func (b *Blocks) Xor(key *Blocks) *Blocks {
 }
 
 
+/**
+ * XORs key into dst in place, repeating the key as Xor does. Allocates
+ * nothing.
+ */
+func XorInto(dst []byte, key []byte) {
+  if len(key) == 0 {
+    return
+  }
+  for i := range dst {
+    dst[i] ^= key[i % len(key)]
+  }
+}
+
+
 /**
  * Returns the hamming distance between two Blocks (the number of differing
  * bits). It is an error to compare different-sized Blocks.
@@ -144,8 +183,23 @@ func (b *Blocks) Xor(key *Blocks) *Blocks {
  * https://cryptopals.com/sets/1/challenges/6
  */
 func (b* Blocks) HammingDistance(other* Blocks) int {
+  differing, err := b.HammingDistanceE(other)
+  if err != nil {
+    panic(err)
+  }
+  return differing
+}
+
+
+/**
+ * Returns the hamming distance between two Blocks, or ErrMismatchedLength if
+ * they are different sizes.
+ */
+func (b* Blocks) HammingDistanceE(other* Blocks) (int, error) {
   if b.buf.Len() != other.buf.Len() {
-    panic("Cannot compute Hamming distance for mismatched Blocks.")
+    return 0, fmt.Errorf(
+        "%w Cannot compute Hamming distance for %d and %d bytes.",
+        ErrMismatchedLength, b.buf.Len(), other.buf.Len())
   }
   differing := 0
   b_bytes := b.buf.Bytes()
@@ -160,7 +214,7 @@ func (b* Blocks) HammingDistance(other* Blocks) int {
     //    "%s 0x%x\t%s 0x%x\t%d",
     //    string(a_byte), a_byte, string(b_bytes[i]), b_bytes[i], differing)
   }
-  return differing
+  return differing, nil
 }
 
 
@@ -188,21 +242,26 @@ func (b* Blocks) GetMinimumAndAverageHammingDistance() (float64, float64) {
 }
 
 
+/**
+ * Decodes hex. An odd number of digits is accepted, as if there were a leading
+ * 0. Panics on invalid input; see ParseHex.
+ */
 func FromHex(encoded_hex string) *Blocks {
   encoded_runes := []rune(encoded_hex)
   var decoded bytes.Buffer
   if len(encoded_runes) == 0 {
-    FromBytesBuffer(decoded)
+    return FromBytesBuffer(decoded)
   }
   for low_nibble_index := (len(encoded_runes) - 1) % 2;
       low_nibble_index < len(encoded_runes);
       low_nibble_index += 2 {
     var decoded_byte byte = 0x0
     if low_nibble_index - 1 >= 0 {
-      decoded_byte |= hex_char_to_byte(encoded_runes[low_nibble_index - 1]) << 4
+      decoded_byte |= must_hex_char_to_byte(
+          encoded_runes, low_nibble_index - 1) << 4
       //fmt.Printf("%s", string(encoded_runes[low_nibble_index - 1]))
     }
-    decoded_byte |= hex_char_to_byte(encoded_runes[low_nibble_index])
+    decoded_byte |= must_hex_char_to_byte(encoded_runes, low_nibble_index)
     //fmt.Printf(
     //    "%s => 0x%x\n", string(encoded_runes[low_nibble_index]), decoded_byte)
     decoded.WriteByte(decoded_byte)
@@ -211,6 +270,32 @@ func FromHex(encoded_hex string) *Blocks {
 }
 
 
+/**
+ * Decodes hex, returning ErrOddHex for an odd number of digits or an
+ * *InvalidHexError for a non-hex character.
+ */
+func ParseHex(encoded_hex string) (*Blocks, error) {
+  encoded_runes := []rune(encoded_hex)
+  if len(encoded_runes) % 2 != 0 {
+    return nil, fmt.Errorf(
+        "%w Got %d digits.", ErrOddHex, len(encoded_runes))
+  }
+  var decoded bytes.Buffer
+  for i := 0; i < len(encoded_runes); i += 2 {
+    high, err := hex_char_to_byte(encoded_runes, i)
+    if err != nil {
+      return nil, err
+    }
+    low, err := hex_char_to_byte(encoded_runes, i + 1)
+    if err != nil {
+      return nil, err
+    }
+    decoded.WriteByte(high << 4 | low)
+  }
+  return FromBytesBuffer(decoded), nil
+}
+
+
 func (b* Blocks) ToHex() string {
   var encoded bytes.Buffer
   for _, in_byte := range b.buf.Bytes() {
@@ -232,17 +317,27 @@ func nibble_to_hex_string(value byte) string {
 }
 
 
-func hex_char_to_byte(value rune) byte {
+func hex_char_to_byte(encoded_runes []rune, i int) (byte, error) {
+  value := encoded_runes[i]
   if value >= '0' && value <= '9' {
-    return byte(value - '0')
+    return byte(value - '0'), nil
   } else if value >= 'a' && value <= 'f' {
-    return 10 + byte(value - 'a')
+    return 10 + byte(value - 'a'), nil
   } else {
-    panic(fmt.Sprintf("Rune %q is invalid as hex.", value))
+    return 0x0, &InvalidHexError{Rune: value, Index: i}
   }
 }
 
 
+func must_hex_char_to_byte(encoded_runes []rune, i int) byte {
+  value, err := hex_char_to_byte(encoded_runes, i)
+  if err != nil {
+    panic(err)
+  }
+  return value
+}
+
+
 func to_base64_char(value byte) string {
   i := value
   if i <= 'Z' - 'A' {
@@ -304,21 +399,53 @@ func (b *Blocks) ToBase64() string {
 
 
 func FromBase64(encoded string) *Blocks {
-  data, err := base64.StdEncoding.DecodeString(encoded)
+  decoded, err := ParseBase64(encoded)
   if err != nil {
     panic(err)
   }
-  return FromBytes(data)
+  return decoded
+}
+
+
+/** Decodes standard (padded) base64, returning an error on invalid input. */
+func ParseBase64(encoded string) (*Blocks, error) {
+  data, err := base64.StdEncoding.DecodeString(encoded)
+  if err != nil {
+    return nil, err
+  }
+  return FromBytes(data), nil
 }
 
 
 func FromBase64Stream(input_stream io.Reader) *Blocks {
+  text, err := ParseBase64Stream(input_stream)
+  if err != nil {
+    panic(err)
+  }
+  return text
+}
+
+
+/**
+ * Decodes base64 one line at a time, concatenating the results. Returns an
+ * error naming the first line which cannot be decoded.
+ */
+func ParseBase64Stream(input_stream io.Reader) (*Blocks, error) {
   text := New()
   scanner := bufio.NewScanner(input_stream)
+  line_num := 1
   for scanner.Scan() {
-    text.Append(FromBase64(scanner.Text()))
+    decoded, err := ParseBase64(scanner.Text())
+    if err != nil {
+      return nil, fmt.Errorf("Line %d: %w", line_num, err)
+    }
+    text.Append(decoded)
+    line_num++
   }
-  return text
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  return text, nil
 }
 
 
@@ -350,27 +477,72 @@ func (b *Blocks) NumBlocks() int {
 
 
 /**
- * Returns one block (by index) from this Blocks, wrapped in a new Blocks.
+ * Returns a copy of one block (by index) from this Blocks, in a new Blocks.
  * This does no padding, so the last block may be less the block_size long.
+ * BlockView avoids the copy.
  */
 func (b *Blocks) Block(i int) *Blocks {
-  if i >= b.NumBlocks() {
-    panic(fmt.Sprintf(
-        "Cannot get block %d >= block count %d (for %d bytes).",
-        i, b.NumBlocks(), b.buf.Len()))
+  extracted, err := b.BlockE(i)
+  if err != nil {
+    panic(err)
+  }
+  return extracted
+}
+
+
+/** Returns one block (as Block does), or ErrBlockIndex if out of range. */
+func (b *Blocks) BlockE(i int) (*Blocks, error) {
+  if i < 0 || i >= b.NumBlocks() {
+    return nil, fmt.Errorf(
+        "%w Cannot get block %d >= block count %d (for %d bytes).",
+        ErrBlockIndex, i, b.NumBlocks(), b.buf.Len())
   }
+  extracted := FromBytes(append([]byte(nil), b.block_view(i)...))
+  extracted.block_size = b.block_size
+  return extracted, nil
+}
+
+
+/**
+ * Returns one block (by index) as a slice of these Blocks' bytes, without
+ * copying. The view is only valid until these Blocks are next appended to,
+ * and writing to it writes to these Blocks.
+ */
+func (b *Blocks) BlockView(i int) []byte {
+  if i < 0 || i >= b.NumBlocks() {
+    panic(fmt.Errorf(
+        "%w Cannot get block %d >= block count %d (for %d bytes).",
+        ErrBlockIndex, i, b.NumBlocks(), b.buf.Len()))
+  }
+  return b.block_view(i)
+}
+
+
+func (b *Blocks) block_view(i int) []byte {
   start := b.block_size * i
   end := b.block_size * (i + 1)
   if end >= b.buf.Len() {  // go has no integer min
     end = b.buf.Len()
   }
-  extracted := FromBytes(b.buf.Bytes()[start:end])
-  extracted.block_size = b.block_size
-  return extracted
+  return b.buf.Bytes()[start:end:end]
 }
 
 
-/** Returns one block, padded if necessary with 0x04 (PKCS#7 padding). */
+/**
+ * Calls visit with each block's index and a view of it (as from BlockView),
+ * in order. The last block may be short.
+ */
+func (b *Blocks) EachBlock(visit func(i int, block []byte)) {
+  for i := 0; i < b.NumBlocks(); i++ {
+    visit(i, b.block_view(i))
+  }
+}
+
+
+/**
+ * Returns one block, padded if necessary with 0x04. (This is not PKCS#7; see
+ * PadPKCS7 for padding a whole message.)
+ */
 func (b *Blocks) BlockPadded(i int) *Blocks {
   extracted := b.Block(i)
   for extracted.buf.Len() < extracted.block_size {
@@ -380,6 +552,67 @@ func (b *Blocks) BlockPadded(i int) *Blocks {
 }
 
 
+/**
+ * Returned by UnpadPKCS7 when the data does not end in valid PKCS#7 padding.
+ */
+type PaddingError struct {
+  Length int  // length of the (supposedly) padded data
+  PadByte byte  // the final byte, which should give the padding length
+}
+
+
+func (e *PaddingError) Error() string {
+  return fmt.Sprintf(
+      "Invalid PKCS#7 padding: %d bytes ending in 0x%x.", e.Length, e.PadByte)
+}
+
+
+/**
+ * Returns a copy of these Blocks with PKCS#7 padding added, making the length
+ * a multiple of block_size. Aligned input gets a full block of padding, so the
+ * padding can always be removed unambiguously.
+ * https://cryptopals.com/sets/2/challenges/9
+ */
+func (b *Blocks) PadPKCS7(block_size int) *Blocks {
+  if block_size <= 0 || block_size > 0xFF {
+    panic(fmt.Sprintf("Block size %d cannot be PKCS#7 padded.", block_size))
+  }
+  padded := b.Copy()
+  padded.SetBlockSize(block_size)
+  pad_length := block_size - b.buf.Len() % block_size
+  for i := 0; i < pad_length; i++ {
+    padded.buf.WriteByte(byte(pad_length))
+  }
+  return padded
+}
+
+
+/**
+ * Returns a copy of these Blocks with PKCS#7 padding removed, using these
+ * Blocks' block size. Returns a *PaddingError if the padding is malformed.
+ * https://cryptopals.com/sets/2/challenges/15
+ */
+func (b *Blocks) UnpadPKCS7() (*Blocks, error) {
+  data := b.buf.Bytes()
+  if len(data) == 0 || len(data) % b.block_size != 0 {
+    return nil, &PaddingError{Length: len(data)}
+  }
+  pad_byte := data[len(data) - 1]
+  pad_length := int(pad_byte)
+  if pad_length == 0 || pad_length > b.block_size {
+    return nil, &PaddingError{Length: len(data), PadByte: pad_byte}
+  }
+  for _, value := range data[len(data) - pad_length:] {
+    if value != pad_byte {
+      return nil, &PaddingError{Length: len(data), PadByte: pad_byte}
+    }
+  }
+  unpadded := FromBytes(data[:len(data) - pad_length]).Copy()
+  unpadded.SetBlockSize(b.block_size)
+  return unpadded, nil
+}
+
+
 /**
  * Returns a transposed copy of these Blocks. The first block of the returned
  * Blocks will have the first byte of each of the original blocks, and so on.
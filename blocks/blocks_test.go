@@ -1,5 +1,7 @@
 package blocks
 
+import "errors"
+import "strings"
 import "testing"
 
 
@@ -147,3 +149,168 @@ func TestRepeatByte(t *testing.T) {
         expected.ToString(), repeated.ToString())
   }
 }
+
+
+func TestPadPKCS7(t *testing.T) {
+  padded := FromString("YELLOW SUBMARINE").PadPKCS7(20)
+  expected := "YELLOW SUBMARINE\x04\x04\x04\x04"
+  if padded.ToString() != expected {
+    t.Errorf("Expected padded as %q but got %q.", expected, padded.ToString())
+  }
+  aligned := FromString("YELLOW SUBMARINE").PadPKCS7(16)
+  if aligned.Len() != 32 || aligned.ToBytes()[31] != 16 {
+    t.Errorf("Aligned input should gain a full block, got %q.",
+        aligned.ToString())
+  }
+}
+
+
+func TestUnpadPKCS7(t *testing.T) {
+  for _, text := range [...]string{"", "ICE", "ICE ICE BABY\x04\x04\x04\x04"} {
+    unpadded, err := FromString(text).PadPKCS7(16).UnpadPKCS7()
+    if err != nil {
+      t.Errorf("Unexpected error unpadding %q: %s", text, err)
+    } else if unpadded.ToString() != text {
+      t.Errorf("Expected unpadded as %q but got %q.", text, unpadded.ToString())
+    }
+  }
+}
+
+
+func TestUnpadPKCS7Invalid(t *testing.T) {
+  for _, text := range [...]string{
+      "",
+      "ICE ICE BABY\x05\x05\x05\x05",
+      "ICE ICE BABY\x01\x02\x03\x04",
+      "ICE ICE BABY\x00\x00\x00\x00",
+      "ICE ICE BABY\x11\x11\x11\x11",
+      "ICE ICE BABY\x01"} {
+    unpadded, err := FromString(text).UnpadPKCS7()
+    if _, ok := err.(*PaddingError); !ok {
+      t.Errorf(
+          "Expected a PaddingError for %q but got %v (%v).",
+          text, err, unpadded)
+    }
+  }
+}
+
+
+func TestParseHex(t *testing.T) {
+  decoded, err := ParseHex("49276d")
+  if err != nil || decoded.ToString() != "I'm" {
+    t.Errorf("Expected \"I'm\" but got %v (%v).", decoded, err)
+  }
+  if _, err := ParseHex("49276"); !errors.Is(err, ErrOddHex) {
+    t.Errorf("Expected ErrOddHex but got %v.", err)
+  }
+  _, err = ParseHex("4927zd")
+  if hex_err, ok := err.(*InvalidHexError); !ok || hex_err.Index != 4 {
+    t.Errorf("Expected InvalidHexError at 4 but got %v.", err)
+  }
+}
+
+
+func TestParseBase64(t *testing.T) {
+  if _, err := ParseBase64("TWE="); err != nil {
+    t.Errorf("Unexpected error %s.", err)
+  }
+  if _, err := ParseBase64("TWE"); err == nil {
+    t.Errorf("Expected an error for truncated base64.")
+  }
+  _, err := ParseBase64Stream(strings.NewReader("TWFu\nT!E=\n"))
+  if err == nil || !strings.HasPrefix(err.Error(), "Line 2:") {
+    t.Errorf("Expected an error on line 2 but got %v.", err)
+  }
+}
+
+
+func TestHammingDistanceMismatched(t *testing.T) {
+  _, err := FromString("abc").HammingDistanceE(FromString("ab"))
+  if !errors.Is(err, ErrMismatchedLength) {
+    t.Errorf("Expected ErrMismatchedLength but got %v.", err)
+  }
+}
+
+
+func TestBlockOutOfRange(t *testing.T) {
+  b := FromString("abcdABCDqr")
+  b.SetBlockSize(4)
+  for _, i := range [...]int{-1, 3} {
+    if _, err := b.BlockE(i); !errors.Is(err, ErrBlockIndex) {
+      t.Errorf("Expected ErrBlockIndex for block %d but got %v.", i, err)
+    }
+  }
+}
+
+
+func TestBlockIsACopy(t *testing.T) {
+  b := FromString("abcdABCDqr")
+  b.SetBlockSize(4)
+  first := b.Block(0)
+  first.AppendByte('!')
+  first.ToBytes()[0] = 'z'
+  if b.ToString() != "abcdABCDqr" {
+    t.Errorf("Changing a Block changed its parent to %q.", b.ToString())
+  }
+}
+
+
+func TestBlockView(t *testing.T) {
+  b := FromString("abcdABCDqr")
+  b.SetBlockSize(4)
+  if string(b.BlockView(2)) != "qr" {
+    t.Errorf("Expected the short last block but got %q.", b.BlockView(2))
+  }
+  b.BlockView(1)[0] = 'X'
+  if b.ToString() != "abcdXBCDqr" {
+    t.Errorf("Writing to a view did not write through: %q.", b.ToString())
+  }
+  var visited []string
+  b.EachBlock(func(i int, block []byte) {
+    if string(block) != string(b.BlockView(i)) {
+      t.Errorf("Block %d is %q, expected %q.", i, block, b.BlockView(i))
+    }
+    visited = append(visited, string(block))
+  })
+  if len(visited) != 3 {
+    t.Errorf("Visited %d blocks, expected 3.", len(visited))
+  }
+}
+
+
+func TestXorInto(t *testing.T) {
+  data := FromString("abcdef")
+  key := FromString("\x01\x02")
+  expected := data.Xor(key)
+  dst := data.Copy().ToBytes()
+  XorInto(dst, key.ToBytes())
+  if string(dst) != expected.ToString() {
+    t.Errorf("Expected %q but got %q.", expected.ToString(), dst)
+  }
+}
+
+
+var benchmark_blocks = RepeatByte('x', 1 << 16)
+
+
+func BenchmarkBlock(b *testing.B) {
+  b.ReportAllocs()
+  for n := 0; n < b.N; n++ {
+    for i := 0; i < benchmark_blocks.NumBlocks(); i++ {
+      benchmark_blocks.Block(i).Xor(benchmark_blocks.Block(0))
+    }
+  }
+}
+
+
+func BenchmarkEachBlock(b *testing.B) {
+  b.ReportAllocs()
+  scratch := make([]byte, default_block_size)
+  key := benchmark_blocks.BlockView(0)
+  for n := 0; n < b.N; n++ {
+    benchmark_blocks.EachBlock(func(i int, block []byte) {
+      copy(scratch, block)
+      XorInto(scratch, key)
+    })
+  }
+}
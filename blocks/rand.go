@@ -0,0 +1,84 @@
+/**
+ * Sources of randomness for the random helpers. CryptoRand is the default; a
+ * seeded source makes attack demos and tests reproducible, so a failing run
+ * can be replayed from its seed.
+ */
+
+package blocks
+
+import crypto_rand "crypto/rand"
+import "encoding/binary"
+import "log"
+import "math/big"
+import math_rand "math/rand"
+
+
+/** Random bytes and integers. *math/rand.Rand satisfies this. */
+type Rand interface {
+  Read(p []byte) (int, error)
+  // Returns a uniform integer in [0, n).
+  Intn(n int) int
+}
+
+
+type crypto_source struct{}
+
+
+func (crypto_source) Read(p []byte) (int, error) {
+  return crypto_rand.Read(p)
+}
+
+
+func (crypto_source) Intn(n int) int {
+  v, err := crypto_rand.Int(crypto_rand.Reader, big.NewInt(int64(n)))
+  if err != nil {
+    panic(err)
+  }
+  return int(v.Int64())
+}
+
+
+/** Cryptographically secure randomness, from crypto/rand. */
+var CryptoRand Rand = crypto_source{}
+
+
+/**
+ * Returns a deterministic source: the same seed always gives the same
+ * sequence. This is for reproducing demos and tests, not for real keys.
+ */
+func NewSeededRand(seed int64) Rand {
+  return math_rand.New(math_rand.NewSource(seed))
+}
+
+
+/** Returns a random (non-zero) seed for NewSeededRand, from CryptoRand. */
+func RandomSeed() int64 {
+  buf := make([]byte, 8)
+  for {
+    if _, err := CryptoRand.Read(buf); err != nil {
+      panic(err)
+    }
+    if seed := int64(binary.LittleEndian.Uint64(buf)); seed != 0 {
+      return seed
+    }
+  }
+}
+
+
+/** Help for a command's --seed flag, whose value goes to SeededOrRandom. */
+const SeedFlagHelp string =
+    "Seed for the random choices, to replay a run. By default, random."
+
+
+/**
+ * Returns a seeded source for a command's --seed flag, and the seed used: the
+ * given one, or a random one if it is 0. The seed is logged, so that any run
+ * can be replayed.
+ */
+func SeededOrRandom(seed int64) (Rand, int64) {
+  if seed == 0 {
+    seed = RandomSeed()
+  }
+  log.Printf("Using random seed %d (replay with --seed).", seed)
+  return NewSeededRand(seed), seed
+}
@@ -0,0 +1,54 @@
+package blocks
+
+import "testing"
+
+
+func TestSeededRandRepeats(t *testing.T) {
+  a := NewSeededRand(42)
+  b := NewSeededRand(42)
+  if !Equal(RandomBlockFrom(a, 32), RandomBlockFrom(b, 32)) {
+    t.Errorf("The same seed gave different bytes.")
+  }
+  for i := 0; i < 10; i++ {
+    if x, y := a.Intn(1000), b.Intn(1000); x != y {
+      t.Errorf("The same seed gave different Intn: %d and %d.", x, y)
+    }
+  }
+  c := NewSeededRand(43)
+  if Equal(RandomBlockFrom(NewSeededRand(42), 32), RandomBlockFrom(c, 32)) {
+    t.Errorf("Different seeds gave the same bytes.")
+  }
+}
+
+
+func TestCryptoRand(t *testing.T) {
+  if RandomBlockFrom(CryptoRand, 16).Len() != 16 {
+    t.Errorf("Expected 16 random bytes.")
+  }
+  for i := 0; i < 100; i++ {
+    if n := CryptoRand.Intn(5); n < 0 || n >= 5 {
+      t.Errorf("Intn(5) returned %d.", n)
+    }
+  }
+  if RandomSeed() == 0 {
+    t.Errorf("RandomSeed returned 0.")
+  }
+}
+
+
+func TestSeededOrRandom(t *testing.T) {
+  r, seed := SeededOrRandom(42)
+  if seed != 42 {
+    t.Errorf("Expected seed 42 but got %d.", seed)
+  }
+  if !Equal(RandomBlockFrom(r, 16), RandomBlockFrom(NewSeededRand(42), 16)) {
+    t.Errorf("Seed 42 did not give NewSeededRand(42)'s bytes.")
+  }
+  r, seed = SeededOrRandom(0)
+  if seed == 0 {
+    t.Errorf("Expected a random seed for 0.")
+  }
+  if !Equal(RandomBlockFrom(r, 16), RandomBlockFrom(NewSeededRand(seed), 16)) {
+    t.Errorf("The returned seed %d does not replay the source.", seed)
+  }
+}
@@ -0,0 +1,225 @@
+/**
+ * Detects ECB from ciphertext alone: ECB encrypts equal plaintext blocks to
+ * equal ciphertext blocks, while chained modes such as CBC make a repeat as
+ * unlikely as a collision of random blocks.
+ * https://cryptopals.com/sets/1/challenges/8
+ * https://cryptopals.com/sets/2/challenges/11
+ */
+
+package detect
+
+import "fmt"
+import "math"
+import "sort"
+
+import "../aes_modes"
+import "../blocks"
+
+
+/**
+ * Returns how many of the full blocks of size block_size repeat an earlier
+ * block, and how many full blocks there are. A partial final block is ignored.
+ */
+func CountRepeats(ciphertext *blocks.Blocks, block_size int) (int, int) {
+  if block_size < 1 {
+    panic(fmt.Sprintf("Block size must be positive, not %d.", block_size))
+  }
+  data := ciphertext.ToBytes()
+  num_blocks := len(data) / block_size
+  seen := make(map[string]bool, num_blocks)
+  repeats := 0
+  for start := 0; start + block_size <= len(data); start += block_size {
+    block := string(data[start:start + block_size])
+    if seen[block] {
+      repeats++
+    }
+    seen[block] = true
+  }
+  return repeats, num_blocks
+}
+
+
+/**
+ * Calls repeats ECB only if random blocks would repeat as often less than this
+ * often, so that chance repeats of small blocks are not mistaken for ECB.
+ */
+const ecb_significance float64 = 0.01
+
+
+/**
+ * Returns the expected number of repeats among num_blocks random blocks: the
+ * blocks less the expected number of distinct values.
+ */
+func expected_repeats(num_blocks int, block_size int) float64 {
+  n := float64(num_blocks)
+  if block_size > 4 {
+    // Too many values for float64 precision; count colliding pairs instead.
+    return n * (n - 1) / 2 * math.Pow(2, -8 * float64(block_size))
+  }
+  values := math.Pow(2, 8 * float64(block_size))
+  distinct := values * -math.Expm1(n * math.Log1p(-1 / values))
+  return math.Max(0, n - distinct)
+}
+
+
+/** Returns the log of the Poisson probability of k, with mean mean. */
+func poisson_log_pmf(k int, mean float64) float64 {
+  log_factorial, _ := math.Lgamma(float64(k + 1))
+  return float64(k) * math.Log(mean) - mean - log_factorial
+}
+
+
+/**
+ * Returns P(X >= at_least) for X Poisson with mean mean, summing in log space
+ * from the end of the range nearer the mean, so that neither exp(-mean) nor
+ * the terms far from the mean need to be representable.
+ */
+func poisson_upper_tail(at_least int, mean float64) float64 {
+  if at_least <= 0 {
+    return 1
+  }
+  if mean <= 0 {
+    return 0
+  }
+  if float64(at_least) > mean {
+    // The terms from at_least up fall away from the mean.
+    total := 0.0
+    for k := at_least; ; k++ {
+      term := math.Exp(poisson_log_pmf(k, mean))
+      total += term
+      if term <= total * 1e-17 {
+        return math.Min(1, total)
+      }
+    }
+  }
+  // The terms from at_least - 1 down fall away from the mean.
+  below := 0.0
+  for k := at_least - 1; k >= 0; k-- {
+    term := math.Exp(poisson_log_pmf(k, mean))
+    below += term
+    if term <= below * 1e-17 {
+      break
+    }
+  }
+  return math.Max(0, 1 - below)
+}
+
+
+/**
+ * Returns the mean and variance of the number of distinct values among n
+ * uniformly random blocks of block_size bytes. The exponentials are rearranged
+ * (with log1p and expm1) to avoid cancellation.
+ */
+func distinct_mean_and_variance(
+    num_blocks int, block_size int) (float64, float64) {
+  n := float64(num_blocks)
+  values := math.Pow(2, 8 * float64(block_size))
+  // P(a given value is unseen), and P(two given values are both unseen).
+  a := math.Exp(n * math.Log1p(-1 / values))
+  b := math.Exp(n * math.Log1p(-2 / values))
+  mean := values * -math.Expm1(n * math.Log1p(-1 / values))
+  // values * (a - b) + values^2 * (b - a^2)
+  variance := values * b * math.Expm1(n * math.Log1p(1 / (values - 2))) +
+      values * values * a * a *
+          math.Expm1(n * math.Log1p(-1 / ((values - 1) * (values - 1))))
+  return mean, math.Max(0, variance)
+}
+
+
+/**
+ * Returns the probability that random (CBC-like) blocks would repeat at least
+ * as often. Rare repeats are Poisson distributed. When many are expected
+ * among small blocks, the Poisson is far too wide, since the number of
+ * distinct values is nearly fixed as every value is seen; that number is
+ * then taken as normal, with its exact mean and variance.
+ */
+func chance_of_repeats(repeats int, num_blocks int, block_size int) float64 {
+  if repeats == 0 {
+    return 1
+  }
+  expected := expected_repeats(num_blocks, block_size)
+  if block_size > 4 || expected < 10 {
+    return poisson_upper_tail(repeats, expected)
+  }
+  mean, variance := distinct_mean_and_variance(num_blocks, block_size)
+  // P(at most this many distinct values), with a continuity correction.
+  margin := float64(num_blocks - repeats) + 0.5 - mean
+  if variance == 0 {
+    if margin >= 0 {
+      return 1
+    }
+    return 0
+  }
+  return 0.5 * math.Erfc(-margin / math.Sqrt(2 * variance))
+}
+
+
+/**
+ * Guesses whether the ciphertext is ECB, with a confidence in [0.5, 1] that the
+ * guess is right. Repeated blocks, more than chance allows at this block size,
+ * mean ECB. Otherwise the guess is CBC by elimination, at confidence 0.5: ECB
+ * of a plaintext without repeated blocks looks the same.
+ */
+func DetectMode(
+    ciphertext *blocks.Blocks, block_size int) (aes_modes.Mode, float64) {
+  repeats, num_blocks := CountRepeats(ciphertext, block_size)
+  return detect_mode(repeats, num_blocks, block_size)
+}
+
+
+func detect_mode(
+    repeats int, num_blocks int, block_size int) (aes_modes.Mode, float64) {
+  chance := chance_of_repeats(repeats, num_blocks, block_size)
+  if chance < ecb_significance {
+    return aes_modes.ModeEcb, 1 - chance
+  }
+  return aes_modes.ModeCbc, 0.5
+}
+
+
+/** One ciphertext's place in a Rank. */
+type Candidate struct {
+  Index int  // In the ciphertexts given to Rank.
+  Mode aes_modes.Mode
+  Confidence float64
+  Repeats int
+  NumBlocks int
+}
+
+
+/**
+ * Runs DetectMode on each ciphertext and sorts them most ECB-like first: ECB
+ * guesses by confidence, then by fraction of repeated blocks.
+ */
+func Rank(ciphertexts []*blocks.Blocks, block_size int) []Candidate {
+  candidates := make([]Candidate, len(ciphertexts))
+  for i, ciphertext := range ciphertexts {
+    repeats, num_blocks := CountRepeats(ciphertext, block_size)
+    mode, confidence := detect_mode(repeats, num_blocks, block_size)
+    candidates[i] = Candidate{
+        Index: i,
+        Mode: mode,
+        Confidence: confidence,
+        Repeats: repeats,
+        NumBlocks: num_blocks}
+  }
+  sort.SliceStable(candidates, func(i, j int) bool {
+    a, b := candidates[i], candidates[j]
+    if (a.Mode == aes_modes.ModeEcb) != (b.Mode == aes_modes.ModeEcb) {
+      return a.Mode == aes_modes.ModeEcb
+    }
+    if a.Mode == aes_modes.ModeEcb && a.Confidence != b.Confidence {
+      return a.Confidence > b.Confidence
+    }
+    return a.repeat_fraction() > b.repeat_fraction()
+  })
+  return candidates
+}
+
+
+func (c Candidate) repeat_fraction() float64 {
+  if c.NumBlocks == 0 {
+    return 0
+  }
+  return float64(c.Repeats) / float64(c.NumBlocks)
+}
@@ -0,0 +1,153 @@
+package detect
+
+import "testing"
+
+import "../aes_modes"
+import "../blocks"
+
+
+func TestCountRepeats(t *testing.T) {
+  ciphertext := blocks.FromString("abcXYZabcabcX")
+  repeats, num_blocks := CountRepeats(ciphertext, 3)
+  if repeats != 2 || num_blocks != 4 {
+    t.Errorf(
+        "Expected 2 repeats of 4 blocks but got %d of %d.",
+        repeats, num_blocks)
+  }
+  repeats, num_blocks = CountRepeats(ciphertext, 5)
+  if repeats != 0 || num_blocks != 2 {
+    t.Errorf(
+        "Expected 0 repeats of 2 blocks but got %d of %d.",
+        repeats, num_blocks)
+  }
+}
+
+
+func TestDetectMode(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  iv := blocks.RepeatByte(0, 16)
+  plaintext := blocks.RepeatByte('a', 64)
+  mode, confidence := DetectMode(aes_modes.EcbEncrypt(plaintext, key), 16)
+  if mode != aes_modes.ModeEcb || confidence < 0.999 {
+    t.Errorf("Expected confident ECB but got %s at %f.", mode, confidence)
+  }
+  mode, confidence = DetectMode(aes_modes.CbcEncrypt(plaintext, key, iv), 16)
+  if mode != aes_modes.ModeCbc || confidence != 0.5 {
+    t.Errorf("Expected CBC at 0.5 but got %s at %f.", mode, confidence)
+  }
+}
+
+
+func TestDetectModeSmallBlocks(t *testing.T) {
+  // With 1-byte blocks, some repeats are expected by chance alone.
+  random := blocks.RandomBlockFrom(blocks.NewSeededRand(1), 64)
+  if mode, _ := DetectMode(random, 1); mode != aes_modes.ModeCbc {
+    t.Errorf("Chance 1-byte repeats in %s were taken as ECB.", random.ToHex())
+  }
+  if mode, _ := DetectMode(blocks.RepeatByte('x', 64), 1); mode !=
+      aes_modes.ModeEcb {
+    t.Errorf("64 equal bytes were not taken as ECB.")
+  }
+  if mode, _ := DetectMode(blocks.FromString("ab"), 2); mode !=
+      aes_modes.ModeCbc {
+    t.Errorf("A single block was taken as ECB.")
+  }
+  // Many more blocks than values, where every value repeats by chance.
+  for _, test := range []struct{
+    length int
+    block_size int
+  }{{1000, 1}, {100000, 1}, {100000, 2}, {1 << 20, 3}} {
+    mode, confidence := DetectMode(
+        blocks.RepeatByte('x', test.length), test.block_size)
+    if mode != aes_modes.ModeEcb || confidence < 0.99 {
+      t.Errorf(
+          "%d equal bytes in %d-byte blocks gave %s at %f, not ECB.",
+          test.length, test.block_size, mode, confidence)
+    }
+    random := blocks.RandomBlockFrom(blocks.NewSeededRand(2), test.length)
+    if mode, _ := DetectMode(random, test.block_size); mode !=
+        aes_modes.ModeCbc {
+      t.Errorf(
+          "%d random bytes in %d-byte blocks were taken as ECB.",
+          test.length, test.block_size)
+    }
+  }
+}
+
+
+func TestRank(t *testing.T) {
+  ciphertexts := []*blocks.Blocks{
+      blocks.FromString("0123456789abcdefABCDEFGHIJKLMNOP"),
+      blocks.FromString("0123456789abcdef0123456789abcdefABCDEFGHIJKLMNOP"),
+      blocks.FromString("0123456789abcdef0123456789abcdef"),
+      blocks.FromString("")}
+  ranked := Rank(ciphertexts, 16)
+  order := []int{}
+  for _, candidate := range ranked {
+    order = append(order, candidate.Index)
+  }
+  if len(order) != 4 || order[0] != 2 || order[1] != 1 {
+    t.Errorf("Expected indexes 2 then 1 first, but got %v.", order)
+  }
+  if ranked[2].Mode != aes_modes.ModeCbc {
+    t.Errorf("Expected no more ECB after 2 candidates, got %v.", ranked[2])
+  }
+}
+
+
+/** Counts of detections, by actual mode then detected mode. */
+type confusion_matrix map[aes_modes.Mode]map[aes_modes.Mode]int
+
+
+func (c confusion_matrix) add(actual aes_modes.Mode, detected aes_modes.Mode) {
+  if c[actual] == nil {
+    c[actual] = map[aes_modes.Mode]int{}
+  }
+  c[actual][detected]++
+}
+
+
+/** Runs DetectMode on trials oracle outputs for the chosen plaintext. */
+func score_detector(
+    plaintext *blocks.Blocks, seed int64, trials int) confusion_matrix {
+  r := blocks.NewSeededRand(seed)
+  matrix := confusion_matrix{}
+  for i := 0; i < trials; i++ {
+    result := aes_modes.RandomEncryptOracle(r, plaintext)
+    detected, _ := DetectMode(result.Ciphertext, 16)
+    matrix.add(result.Mode, detected)
+  }
+  return matrix
+}
+
+
+func TestDetectorAccuracy(t *testing.T) {
+  trials := 5000
+  if testing.Short() {
+    trials = 500
+  }
+  seed := blocks.RandomSeed()
+  matrix := score_detector(blocks.RepeatByte('a', 48), seed, trials)
+
+  ecb, cbc := aes_modes.ModeEcb, aes_modes.ModeCbc
+  correct := matrix[ecb][ecb] + matrix[cbc][cbc]
+  cbc_total := matrix[cbc][ecb] + matrix[cbc][cbc]
+  accuracy := float64(correct) / float64(trials)
+  false_positive_rate := 0.0
+  if cbc_total > 0 {
+    false_positive_rate = float64(matrix[cbc][ecb]) / float64(cbc_total)
+  }
+  t.Logf(
+      "%d trials (seed %d): accuracy %.4f, ECB false positive rate %.4f.",
+      trials, seed, accuracy, false_positive_rate)
+  t.Logf("actual \\ detected\tECB\tCBC")
+  for _, actual := range []aes_modes.Mode{ecb, cbc} {
+    t.Logf(
+        "%s\t\t\t%d\t%d", actual, matrix[actual][ecb], matrix[actual][cbc])
+  }
+  if correct != trials {
+    t.Errorf(
+        "Detection accuracy %.4f is below 100%% (replay with seed %d).",
+        accuracy, seed)
+  }
+}
@@ -9,11 +9,15 @@
 package main
 
 import (
+  "bytes"
   "crypto/aes"
   "log"
 
+  "github.com/droundy/goopt"
+
   "./blocks"
   "./aes_modes"
+  "./detect"
 )
 
 
@@ -23,13 +27,14 @@ type BlackBox struct {
 }
 
 
-func NewBlackBox() *BlackBox {
+/** Makes a black box whose secret key comes from r. */
+func NewBlackBox(r blocks.Rand) *BlackBox {
   secret_plaintext := blocks.FromBase64(
       "Um9sbGluJyBpbiBteSA1LjAKV2l0aCBteSByYWctdG9wIGRvd24gc28gbXkg" +
       "aGFpciBjYW4gYmxvdwpUaGUgZ2lybGllcyBvbiBzdGFuZGJ5IHdhdmluZyBq" +
       "dXN0IHRvIHNheSBoaQpEaWQgeW91IHN0b3A/IE5vLCBJIGp1c3QgZHJvdmUg" +
       "YnkK")
-  secret_key := blocks.RandomBlock(aes.BlockSize)
+  secret_key := blocks.RandomBlockFrom(r, aes.BlockSize)
   return &BlackBox{plaintext: secret_plaintext, key: secret_key}
 }
 
@@ -64,22 +69,30 @@ func decrypt(black_box *BlackBox, block_size int) *blocks.Blocks {
   for {
     padding := blocks.RepeatByte('*', padding_length)
     encrypted_with_unknown_byte := black_box.EncryptWithPrefix(padding)
+    target_block := encrypted_with_unknown_byte.BlockView(attack_block)
+    // Build the guess once, and vary only its last byte.
+    guess := padding.Copy()
+    guess.Append(decrypted)
+    guess.AppendByte(0)
+    guess_bytes := guess.ToBytes()
     matched := false
     for b := 0x0; b < (0x1 << 8); b++ {
-      possible_plaintext := padding.Copy()
-      possible_plaintext.Append(decrypted)
-      possible_plaintext.AppendByte(byte(b))
-      encrypted_with_known_byte := black_box.EncryptWithPrefix(
-          possible_plaintext)
-      matched = blocks.Equal(
-          encrypted_with_known_byte.Block(attack_block),
-          encrypted_with_unknown_byte.Block(attack_block))
+      guess_bytes[len(guess_bytes) - 1] = byte(b)
+      encrypted_with_known_byte := black_box.EncryptWithPrefix(guess)
+      matched = bytes.Equal(
+          encrypted_with_known_byte.BlockView(attack_block), target_block)
       if matched {
         decrypted.AppendByte(byte(b))
         break
       }
     }
     if !matched {
+      // Having matched the first byte of PKCS#7 padding (0x01), the next
+      // padding byte changes as the prefix shrinks, so nothing matches.
+      last := decrypted.Len() - 1
+      if last >= 0 && decrypted.ToBytes()[last] == 0x01 {
+        return blocks.FromBytes(decrypted.ToBytes()[:last])
+      }
       log.Fatalf("No byte matched after %q.", decrypted.ToString())
     }
 
@@ -97,20 +110,26 @@ func decrypt(black_box *BlackBox, block_size int) *blocks.Blocks {
 
 
 func main() {
-  black_box := NewBlackBox()
+  var seed = goopt.Int([]string{"--seed"}, 0, blocks.SeedFlagHelp)
+  goopt.Description = func() string {
+    return "Decrypt an ECB black box's secret, a byte at a time."
+  }
+  goopt.Parse(nil)
+  r, _ := blocks.SeededOrRandom(int64(*seed))
+
+  black_box := NewBlackBox(r)
 
   block_size := find_block_size(black_box)
   log.Printf("Found black-box encrypter's block size: %d.", block_size)
 
-  repeated_block := blocks.RandomBlock(block_size)
+  repeated_block := blocks.RandomBlockFrom(r, block_size)
   repeated_block.Append(repeated_block)
   repeated_encrypted := black_box.EncryptWithPrefix(repeated_block)
-  repeated_encrypted.SetBlockSize(block_size)
-  min_dist, _ := repeated_encrypted.GetMinimumAndAverageHammingDistance()
-  if min_dist == 0 {
-    log.Printf("Min Hamming dist with repeated block %f: ECB.", min_dist)
+  mode, confidence := detect.DetectMode(repeated_encrypted, block_size)
+  if mode == aes_modes.ModeEcb {
+    log.Printf("Repeated block detected: ECB (confidence %.2f).", confidence)
   } else {
-    log.Fatalf("Min Hamming dist with repeated block %f. Not ECB?", min_dist)
+    log.Fatalf("No repeated block detected. Not ECB?")
   }
 
   log.Printf(
@@ -12,6 +12,8 @@ import (
   "net/url"
   "strconv"
 
+  "github.com/droundy/goopt"
+
   "./blocks"
   "./aes_modes"
 )
@@ -63,8 +65,9 @@ type ProfileCrypter struct {
 }
 
 
-func NewProfileCrypter() *ProfileCrypter {
-  return &ProfileCrypter{key: blocks.RandomBlock(aes.BlockSize)}
+/** Makes a crypter whose secret key comes from r. */
+func NewProfileCrypter(r blocks.Rand) *ProfileCrypter {
+  return &ProfileCrypter{key: blocks.RandomBlockFrom(r, aes.BlockSize)}
 }
 
 
@@ -94,6 +97,13 @@ func make_encrypted_profile_admin(
 
 
 func main() {
+  var seed = goopt.Int([]string{"--seed"}, 0, blocks.SeedFlagHelp)
+  goopt.Description = func() string {
+    return "Edit an ECB-encrypted profile to make it an admin's."
+  }
+  goopt.Parse(nil)
+  r, _ := blocks.SeededOrRandom(int64(*seed))
+
   email := "regular@secure.com&role=admin"
   orig_secret_profile := NewProfile(email)
 
@@ -113,7 +123,7 @@ func main() {
   }
 
   // Encrypt and attack the profile.
-  crypter := NewProfileCrypter()
+  crypter := NewProfileCrypter(r)
   encrypted_profile := crypter.EncryptProfile(orig_secret_profile)
   edited_encrypted_profile := make_encrypted_profile_admin(
       encrypted_profile, crypter)
@@ -6,38 +6,51 @@
 package main
 
 import "bufio"
+import "crypto/aes"
 import "log"
-import "math"
 import "os"
 
+import "./aes_modes"
 import "./blocks"
+import "./detect"
 
 
 func main() {
   if len(os.Args) > 1 {
-    log.Fatalf("Usage: %s < input_base64.txt]", os.Args[0])
+    log.Fatalf("Usage: %s < input_hex.txt", os.Args[0])
   }
 
   scanner := bufio.NewScanner(os.Stdin)
   line_num := 1
-  overall_min_dist := math.Inf(1)
-  min_line := -1
-  min_ciphertext := ""
+  var ciphertexts []*blocks.Blocks
+  var line_nums []int
   for scanner.Scan() {
-    ciphertext := blocks.FromHex(scanner.Text())
-    min_dist, avg_dist := ciphertext.GetMinimumAndAverageHammingDistance()
-    annotation := ""
-    if min_dist < overall_min_dist {
-      overall_min_dist = min_dist
-      annotation = " *"
-      min_line = line_num
-      min_ciphertext = ciphertext.ToBase64()
+    ciphertext, err := blocks.ParseHex(scanner.Text())
+    if err != nil {
+      log.Printf("line %d\tskipped: %s", line_num, err)
+      line_num++
+      continue
     }
-    log.Printf(
-        "line %d\tavg %f\tmin %f%s", line_num, avg_dist, min_dist, annotation)
+    ciphertexts = append(ciphertexts, ciphertext)
+    line_nums = append(line_nums, line_num)
     line_num++
   }
+
+  ranked := detect.Rank(ciphertexts, aes.BlockSize)
+  for _, candidate := range ranked {
+    if candidate.Mode != aes_modes.ModeEcb {
+      break
+    }
+    log.Printf(
+        "line %d\t%d of %d blocks repeated\tECB (confidence %f)",
+        line_nums[candidate.Index], candidate.Repeats, candidate.NumBlocks,
+        candidate.Confidence)
+  }
+  if len(ranked) == 0 || ranked[0].Mode != aes_modes.ModeEcb {
+    log.Fatalf("No line has repeated blocks, so none is evidently ECB.")
+  }
+  best := ranked[0]
   log.Printf(
-      "Line %d had minimum inter-block Hamming distance %f.\n%q\n",
-      min_line, overall_min_dist, min_ciphertext)
+      "Line %d is most likely ECB.\n%q\n",
+      line_nums[best.Index], ciphertexts[best.Index].ToBase64())
 }
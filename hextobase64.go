@@ -9,5 +9,9 @@ func main() {
   if len(os.Args) != 2 {
     log.Fatalf("Usage: %s TEXT_TO_CONVERT", os.Args[0])
   }
-  log.Printf(blocks.FromHex(os.Args[1]).ToBase64())
+  decoded, err := blocks.ParseHex(os.Args[1])
+  if err != nil {
+    log.Fatal(err)
+  }
+  log.Printf(decoded.ToBase64())
 }
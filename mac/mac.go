@@ -0,0 +1,135 @@
+/**
+ * Message authentication codes: raw CBC-MAC, AES-CMAC (RFC 4493) and HMAC
+ * (RFC 2104) over any hash.
+ * https://en.wikipedia.org/wiki/Message_authentication_code
+ */
+
+package mac
+
+import "crypto/aes"
+import "crypto/subtle"
+import "hash"
+
+import "../aes_modes"
+import "../blocks"
+
+
+func must(tag *blocks.Blocks, err error) *blocks.Blocks {
+  if err != nil {
+    panic(err)
+  }
+  return tag
+}
+
+
+/**
+ * Returns the raw CBC-MAC of the PKCS#7 padded message, with a zero IV: the
+ * last block of its CBC encryption. This is only secure for fixed-length
+ * messages; given the tag t of m, the tag of
+ * pad(m) || (m2[0] ^ t) || m2[1:] is the tag of m2.
+ */
+func CbcMac(message *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+  return must(CbcMacE(message, key))
+}
+
+
+/** Like CbcMac, but returns an error for a bad key. */
+func CbcMacE(
+    message *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  return CbcMacIvE(message, key, blocks.RepeatByte(0, aes.BlockSize))
+}
+
+
+/**
+ * CBC-MAC with the given IV. If an attacker controls the IV, they control the
+ * first plaintext block too.
+ */
+func CbcMacIv(
+    message *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) *blocks.Blocks {
+  return must(CbcMacIvE(message, key, iv))
+}
+
+
+func CbcMacIvE(
+    message *blocks.Blocks,
+    key *blocks.Blocks,
+    iv *blocks.Blocks) (*blocks.Blocks, error) {
+  ciphertext, err := aes_modes.CbcEncryptE(message, key, iv)
+  if err != nil {
+    return nil, err
+  }
+  return ciphertext.Block(ciphertext.NumBlocks() - 1), nil
+}
+
+
+/** Returns the CMAC subkeys K1 and K2 for AES with the key. */
+func CmacSubkeys(key *blocks.Blocks) (*blocks.Blocks, *blocks.Blocks) {
+  modes, err := aes_modes.ForKey(key)
+  if err != nil {
+    panic(err)
+  }
+  return modes.CmacSubkeys()
+}
+
+
+/**
+ * Returns the AES-CMAC of the message. Unlike raw CBC-MAC, the last block is
+ * masked with a key-derived subkey, so tags of different-length messages
+ * cannot be spliced together.
+ */
+func Cmac(message *blocks.Blocks, key *blocks.Blocks) *blocks.Blocks {
+  return must(CmacE(message, key))
+}
+
+
+/** Like Cmac, but returns an error for a bad key. */
+func CmacE(
+    message *blocks.Blocks, key *blocks.Blocks) (*blocks.Blocks, error) {
+  modes, err := aes_modes.ForKey(key)
+  if err != nil {
+    return nil, err
+  }
+  return modes.Cmac(message), nil
+}
+
+
+/**
+ * Returns HMAC(key, message) = H((key ^ opad) || H((key ^ ipad) || message)),
+ * with the key hashed first if it is longer than the hash's block size.
+ */
+func Hmac(
+    new_hash func() hash.Hash,
+    key *blocks.Blocks,
+    message *blocks.Blocks) *blocks.Blocks {
+  h := new_hash()
+  key_bytes := key.ToBytes()
+  if len(key_bytes) > h.BlockSize() {
+    h.Write(key_bytes)
+    key_bytes = h.Sum(nil)
+    h.Reset()
+  }
+  padded_key := make([]byte, h.BlockSize())
+  copy(padded_key, key_bytes)
+
+  inner_key := blocks.FromBytes(padded_key).Xor(blocks.RepeatByte(0x36, 1))
+  h.Write(inner_key.ToBytes())
+  h.Write(message.ToBytes())
+  inner := h.Sum(nil)
+
+  outer_key := blocks.FromBytes(padded_key).Xor(blocks.RepeatByte(0x5c, 1))
+  h.Reset()
+  h.Write(outer_key.ToBytes())
+  h.Write(inner)
+  return blocks.FromBytes(h.Sum(nil))
+}
+
+
+/**
+ * Reports whether the tags are equal, taking time independent of where they
+ * differ (so it does not leak how much of a forged tag is right).
+ */
+func Verify(expected *blocks.Blocks, actual *blocks.Blocks) bool {
+  return subtle.ConstantTimeCompare(expected.ToBytes(), actual.ToBytes()) == 1
+}
@@ -0,0 +1,151 @@
+package mac
+
+import "crypto/hmac"
+import "crypto/md5"
+import "crypto/sha1"
+import "crypto/sha256"
+import "errors"
+import "hash"
+import "testing"
+
+import "../aes_modes"
+import "../blocks"
+
+
+// RFC 4493 section 4.
+var rfc4493_key = blocks.FromHex("2b7e151628aed2a6abf7158809cf4f3c")
+var rfc4493_message = blocks.FromHex(
+    "6bc1bee22e409f96e93d7e117393172a" +
+    "ae2d8a571e03ac9c9eb76fac45af8e51" +
+    "30c81c46a35ce411e5fbc1191a0a52ef" +
+    "f69f2445df4f9b17ad2b417be66c3710")
+
+
+func TestCmacSubkeys(t *testing.T) {
+  k1, k2 := CmacSubkeys(rfc4493_key)
+  if k1.ToHex() != "fbeed618357133667c85e08f7236a8de" {
+    t.Errorf("Wrong K1 %s.", k1.ToHex())
+  }
+  if k2.ToHex() != "f7ddac306ae266ccf90bc11ee46d513b" {
+    t.Errorf("Wrong K2 %s.", k2.ToHex())
+  }
+}
+
+
+func TestCmacRfc4493(t *testing.T) {
+  for _, vector := range []struct{
+      length int
+      tag string
+  }{
+      {0, "bb1d6929e95937287fa37d129b756746"},
+      {16, "070a16b46b4d4144f79bdd9dd04a287c"},
+      {40, "dfa66747de9ae63030ca32611497c827"},
+      {64, "51f0bebf7e3b9d92fc49741779363cfe"},
+  } {
+    message := blocks.FromBytes(rfc4493_message.ToBytes()[:vector.length])
+    tag := Cmac(message, rfc4493_key)
+    if tag.ToHex() != vector.tag {
+      t.Errorf(
+          "Length %d: expected %s but got %s.",
+          vector.length, vector.tag, tag.ToHex())
+    }
+  }
+}
+
+
+func TestCbcMacIsLastCbcBlock(t *testing.T) {
+  key := blocks.FromString("YELLOW SUBMARINE")
+  message := blocks.FromString("alert('MZA who was that?');\n")
+  ciphertext := aes_modes.CbcEncrypt(
+      message, key, blocks.RepeatByte(0, 16))
+  tag := CbcMac(message, key)
+  if !blocks.Equal(tag, ciphertext.Block(ciphertext.NumBlocks() - 1)) {
+    t.Errorf("Tag %s is not the last CBC block.", tag.ToHex())
+  }
+}
+
+
+func TestCbcMacLengthExtension(t *testing.T) {
+  // Given only the tags of m1 and m2, splice a third message with m2's tag.
+  key := blocks.FromString("YELLOW SUBMARINE")
+  m1 := blocks.FromString("from=alice&to=bob&amount=10")
+  m2 := blocks.FromString("to=mallory&amount=1000000")
+  t1 := CbcMac(m1, key)
+  t2 := CbcMac(m2, key)
+
+  forged := m1.PadPKCS7(16)
+  m2_bytes := m2.ToBytes()
+  forged.Append(blocks.FromBytes(m2_bytes[:16]).Xor(t1))
+  forged.AppendBytes(m2_bytes[16:])
+  if !Verify(t2, CbcMac(forged, key)) {
+    t.Errorf("Forged message did not get m2's tag.")
+  }
+  // CMAC masks the final block, which breaks the splice.
+  forged_cmac := m1.PadPKCS7(16)
+  forged_cmac.Append(blocks.FromBytes(m2_bytes[:16]).Xor(Cmac(m1, key)))
+  forged_cmac.AppendBytes(m2_bytes[16:])
+  if Verify(Cmac(m2, key), Cmac(forged_cmac, key)) {
+    t.Errorf("The CBC-MAC forgery also worked against CMAC.")
+  }
+}
+
+
+func TestHmacRfc4231(t *testing.T) {
+  // Test case 2.
+  tag := Hmac(
+      sha256.New,
+      blocks.FromString("Jefe"),
+      blocks.FromString("what do ya want for nothing?"))
+  expected := "5bdcc146bf60754e6a042426089575c7" +
+      "5a003f089d2739839dec58b964ec3843"
+  if tag.ToHex() != expected {
+    t.Errorf("Expected %s but got %s.", expected, tag.ToHex())
+  }
+}
+
+
+func TestHmacMatchesStandardLibrary(t *testing.T) {
+  message := blocks.FromString("Ice, ice, baby.")
+  for _, new_hash := range []func() hash.Hash{md5.New, sha1.New, sha256.New} {
+    // Short, block-sized and over-long (hashed first) keys.
+    for _, key_size := range []int{3, 64, 100} {
+      key := blocks.RepeatByte(0x0b, key_size)
+      standard := hmac.New(new_hash, key.ToBytes())
+      standard.Write(message.ToBytes())
+      expected := blocks.FromBytes(standard.Sum(nil))
+      tag := Hmac(new_hash, key, message)
+      if !Verify(expected, tag) {
+        t.Errorf(
+            "Key size %d: expected %s but got %s.",
+            key_size, expected.ToHex(), tag.ToHex())
+      }
+    }
+  }
+}
+
+
+func TestVerify(t *testing.T) {
+  tag := blocks.FromHex("070a16b46b4d4144f79bdd9dd04a287c")
+  if !Verify(tag, tag.Copy()) {
+    t.Errorf("Equal tags did not verify.")
+  }
+  if Verify(tag, blocks.FromHex("070a16b46b4d4144f79bdd9dd04a287d")) {
+    t.Errorf("Different tags verified.")
+  }
+  if Verify(tag, blocks.FromHex("070a16b46b4d4144")) {
+    t.Errorf("Truncated tag verified.")
+  }
+}
+
+
+func TestInvalidKeySize(t *testing.T) {
+  key := blocks.FromString("short")
+  if _, err := CmacE(rfc4493_message, key); !errors.Is(
+      err, aes_modes.ErrInvalidKeySize) {
+    t.Errorf("Cmac: expected ErrInvalidKeySize but got %v.", err)
+  }
+  if _, err := CbcMacE(rfc4493_message, key); !errors.Is(
+      err, aes_modes.ErrInvalidKeySize) {
+    t.Errorf("CbcMac: expected ErrInvalidKeySize but got %v.", err)
+  }
+}
@@ -28,8 +28,13 @@ func main() {
   scanner := bufio.NewScanner(os.Stdin)
   line_num := 1
   for scanner.Scan() {
-    score, key, plaintext := xor_crypt.XorDecrypt(
-        blocks.FromHex(scanner.Text()))
+    ciphertext, err := blocks.ParseHex(scanner.Text())
+    if err != nil {
+      log.Printf("Line %d skipped: %s", line_num, err)
+      line_num++
+      continue
+    }
+    score, key, plaintext := xor_crypt.XorDecrypt(ciphertext)
     if score > max_score {
       best_line_num = line_num
       max_score = score
@@ -13,7 +13,10 @@ import "./xor_crypt"
 
 
 func main() {
-  ciphertext := blocks.FromBase64Stream(os.Stdin)
+  ciphertext, err := blocks.ParseBase64Stream(os.Stdin)
+  if err != nil {
+    log.Fatal(err)
+  }
   key_size := xor_crypt.FindKeySize(ciphertext)
   log.Printf("Guessed key size %d.\n", key_size)
   ciphertext.SetBlockSize(key_size)
@@ -13,7 +13,13 @@ func main() {
   if len(os.Args) != 3 {
     log.Fatalf("Usage: %s HEX_TEXT HEX_TEXT", os.Args[0])
   }
-  log.Printf(
-      "%s\n",
-      blocks.FromHex(os.Args[1]).Xor(blocks.FromHex(os.Args[2])).ToHex())
+  a, err := blocks.ParseHex(os.Args[1])
+  if err != nil {
+    log.Fatal(err)
+  }
+  b, err := blocks.ParseHex(os.Args[2])
+  if err != nil {
+    log.Fatal(err)
+  }
+  log.Printf("%s\n", a.Xor(b).ToHex())
 }